@@ -4,10 +4,15 @@ import (
 	"bufio"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cookie"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type CasperJS struct {
@@ -19,7 +24,46 @@ type CasperJS struct {
 	outputChan chan []byte
 }
 
-func NewCasperJS(path, script, proxyServer, proxyType string) (*CasperJS, error) {
+const BOOTSTRAP_FILENAME = "casperjs_bootstrap.js"
+
+// bootstrapScript gives every casper created by the script the user agent as
+// default, casperjs has no option of its own for it.
+const bootstrapScript = `var userAgent = %s;
+var casperModule = require('casper');
+var create = casperModule.create;
+casperModule.create = function(options) {
+    options = options || {};
+    options.pageSettings = options.pageSettings || {};
+    options.pageSettings.userAgent = options.pageSettings.userAgent || userAgent;
+    return create(options);
+};
+phantom.injectJs(%s);
+`
+
+// NewCasperJSWithUserAgent runs script through a bootstrap written to path,
+// so that its pages send userAgent unless the script sets another one. The
+// user agent is passed as --user-agent too.
+func NewCasperJSWithUserAgent(path, script, userAgent string) (*CasperJS, error) {
+	abs, err := filepath.Abs(script)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	ua, _ := json.Marshal(userAgent)
+	src, _ := json.Marshal(abs)
+	bootstrap := filepath.Join(path, BOOTSTRAP_FILENAME)
+	err = ioutil.WriteFile(bootstrap, []byte(fmt.Sprintf(bootstrapScript, ua, src)), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewCasperJS(path, bootstrap, "", "", "--user-agent="+userAgent)
+}
+
+// NewCasperJS runs script with the options of path and the proxy, args are
+// appended to them.
+func NewCasperJS(path, script, proxyServer, proxyType string, args ...string) (*CasperJS, error) {
 	ret := &CasperJS{
 		Path:       path,
 		inputChan:  make(chan []byte, 10),
@@ -50,6 +94,7 @@ func NewCasperJS(path, script, proxyServer, proxyType string) (*CasperJS, error)
 				"--context="+path)
 		}
 	}
+	ret.Cmd.Args = append(ret.Cmd.Args, args...)
 	stdin, err := ret.Cmd.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -102,38 +147,35 @@ type Cookie struct {
 	Value    string `json:"value"`
 }
 
-func (c *Cookie) getDomain() string {
-	return strings.Trim(c.Domain, ".")
-}
-
-func (c *Cookie) key() string {
-	return c.getDomain() + ";/;" + c.Name
-}
-
-func (c *Cookie) HiggsCookie() map[string]interface{} {
-	return map[string]interface{}{
-		"Domain":   c.getDomain(),
-		"Name":     c.Name,
-		"Value":    c.Value,
-		"Path":     c.Path,
-		"Secure":   c.Secure,
-		"HttpOnly": c.Httponly,
+func (c *Cookie) HiggsCookie() *cookie.Cookie {
+	ret := &cookie.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Domain:   strings.TrimPrefix(c.Domain, "."),
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HttpOnly: c.Httponly,
+		HostOnly: !strings.HasPrefix(c.Domain, "."),
+	}
+	if len(ret.Path) == 0 {
+		ret.Path = "/"
+	}
+	if c.Expiry > 0 {
+		ret.Expires = time.Unix(c.Expiry, 0).UTC()
 	}
+	return ret
 }
 
+// ConvertCookie returns cookies in the format of the cookie jar.
 func (p *CasperJS) ConvertCookie(cookies []*Cookie) []byte {
-	ret := make(map[string]map[string]interface{})
+	ret := make([]*cookie.Cookie, 0, len(cookies))
 	for _, c := range cookies {
-		_, ok := ret[c.getDomain()]
-		if !ok {
-			ret[c.getDomain()] = make(map[string]interface{})
-		}
-		_, ok2 := ret[c.getDomain()][c.key()]
-		if !ok2 {
-			ret[c.getDomain()][c.key()] = c.HiggsCookie()
-		}
+		ret = append(ret, c.HiggsCookie())
+	}
+	b, err := cookie.Format(cookie.FORMAT_JAR, ret)
+	if err != nil {
+		dlog.Warn("convert cookie error: %v", err)
 	}
-	b, _ := json.Marshal(ret)
 	return b
 }
 
@@ -0,0 +1,25 @@
+package casperjs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewCasperJSWithUserAgent(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "casperjs")
+	defer os.RemoveAll(dir)
+	c, err := NewCasperJSWithUserAgent(dir+"/cmd", "login.js", `Mozilla/5.0 "x"`)
+	assert.NoError(t, err)
+	bootstrap := dir + "/cmd/" + BOOTSTRAP_FILENAME
+	assert.Equal(t, bootstrap, c.Cmd.Args[1])
+	assert.Equal(t, `--user-agent=Mozilla/5.0 "x"`, c.Cmd.Args[len(c.Cmd.Args)-1])
+
+	b, _ := ioutil.ReadFile(bootstrap)
+	abs, _ := filepath.Abs("login.js")
+	assert.True(t, strings.HasPrefix(string(b), `var userAgent = "Mozilla/5.0 \"x\"";`))
+	assert.True(t, strings.Contains(string(b), `phantom.injectJs("`+abs+`");`))
+}
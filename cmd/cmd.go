@@ -3,10 +3,13 @@ package cmd
 import (
 	"crypto/rsa"
 	"net/url"
+	"time"
 )
 
 type Command interface {
 	GetMessage() *Output
+	GetMessageTimeout(time.Duration) (*Output, bool)
+	GetHistory() *History
 	SetInputArgs(map[string]string)
 	Finished() bool
 	Successed() bool
@@ -14,6 +17,13 @@ type Command interface {
 	Close() bool
 }
 
+// CookieHolder is implemented by a Command whose cookies can be exported and
+// imported, format is one of the formats of the cookie package.
+type CookieHolder interface {
+	ExportCookies(format string) ([]byte, error)
+	ImportCookies(format string, b []byte) error
+}
+
 type CommandFactory interface {
 	CreateCommand(url.Values) Command
 	CreateCommandWithPrivateKey(url.Values, *rsa.PrivateKey) Command
@@ -39,14 +49,28 @@ const (
 	OUTPUT_PUBLICKEY      = "output_publickey"
 	OUTPUT_VERIFYCODE     = "output_verifycode"
 	OUTPUT_QRCODE         = "output_qrcode"
-	WRONG_RESPONSE	      = "wrong_response"
-	TMPL_BLOCK	      = "tmpl_block"
+	WRONG_RESPONSE        = "wrong_response"
+	TMPL_BLOCK            = "tmpl_block"
+	EXPIRED               = "expired"
+	INVALID_OUTPUT        = "invalid_output"
+	PENDING               = "pending"
+	QUEUED                = "queued"
 )
 
+// IsFinalStatus tells whether no more output follows an output of status.
+func IsFinalStatus(status string) bool {
+	switch status {
+	case FAIL, FINISH_FETCH_DATA, FINISH_ALL, WRONG_RESPONSE, TMPL_BLOCK, EXPIRED, INVALID_OUTPUT:
+		return true
+	}
+	return false
+}
+
 type Output struct {
-	Status    string `json:"status"`
-	NeedParam string `json:"need_param"`
-	Id        string `json:"id"`
-	Data      string `json:"data"`
-	Url 	  string `json:"url"`
+	Status     string `json:"status"`
+	NeedParam  string `json:"need_param"`
+	Id         string `json:"id"`
+	Data       string `json:"data"`
+	Url        string `json:"url"`
+	ErrorClass string `json:"error_class,omitempty"`
 }
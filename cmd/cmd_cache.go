@@ -5,35 +5,91 @@ import (
 	"time"
 )
 
+const (
+	DEFAULT_IDLE_TIMEOUT = 90 * time.Second
+	DEFAULT_MAX_LIFETIME = 30 * time.Minute
+	EXPIRED_KEEP_TIME    = 10 * time.Minute
+)
+
+// ExpiringCommand is implemented by commands with their own expiry. idle is
+// the timeout since the last Touch and max is the timeout since creation, a
+// zero value means the default.
+type ExpiringCommand interface {
+	Expiry() (idle time.Duration, max time.Duration)
+}
+
+type cacheEntry struct {
+	cmd      Command
+	idle     time.Duration
+	deadline time.Time
+	maxTime  time.Time
+}
+
+type expiredEntry struct {
+	history *History
+	time    time.Time
+}
+
 type CommandCache struct {
-	data map[string]Command
-	lock *sync.RWMutex
+	data     map[string]*cacheEntry
+	expired  map[string]*expiredEntry
+	lock     *sync.RWMutex
+	OnExpire func(Command)
 }
 
 func NewCommandCache() *CommandCache {
-	return &CommandCache{
-		data: make(map[string]Command),
-		lock: &sync.RWMutex{},
+	ret := &CommandCache{
+		data:    make(map[string]*cacheEntry),
+		expired: make(map[string]*expiredEntry),
+		lock:    &sync.RWMutex{},
 	}
+	go ret.checkExpire(time.Second)
+	return ret
 }
 
 func (self *CommandCache) SetCommand(c Command) {
+	idle, max := DEFAULT_IDLE_TIMEOUT, DEFAULT_MAX_LIFETIME
+	if ec, ok := c.(ExpiringCommand); ok {
+		i, m := ec.Expiry()
+		if i > 0 {
+			idle = i
+		}
+		if m > 0 {
+			max = m
+		}
+	}
+	now := time.Now()
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	self.data[c.GetId()] = c
-	go func() {
-		timer := time.NewTimer(90 * time.Second)
-		<-timer.C
-		c.Close()
-		self.Delete(c.GetId())
-	}()
+	self.data[c.GetId()] = &cacheEntry{
+		cmd:      c,
+		idle:     idle,
+		deadline: now.Add(idle),
+		maxTime:  now.Add(max),
+	}
+}
+
+// Touch extends the idle timeout of command id, but never beyond its max
+// lifetime.
+func (self *CommandCache) Touch(id string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if e, ok := self.data[id]; ok {
+		e.deadline = time.Now().Add(e.idle)
+	}
 }
 
+// Delete removes command id, keeping its history for EXPIRED_KEEP_TIME like
+// an expired one so that clients can still read its final output.
 func (self *CommandCache) Delete(id string) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
-	if _, ok := self.data[id]; ok {
+	if e, ok := self.data[id]; ok {
 		delete(self.data, id)
+		self.expired[id] = &expiredEntry{
+			history: e.cmd.GetHistory(),
+			time:    time.Now(),
+		}
 	}
 }
 
@@ -45,5 +101,51 @@ func (self *CommandCache) GetCommand(id string) Command {
 	if !ok {
 		return nil
 	}
-	return val
+	return val.cmd
+}
+
+// Expired returns the history of command id if it was removed because of
+// expiry or by Delete, otherwise nil.
+func (self *CommandCache) Expired(id string) *History {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if e, ok := self.expired[id]; ok {
+		return e.history
+	}
+	return nil
+}
+
+func (self *CommandCache) removeExpired(now time.Time) []Command {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ret := []Command{}
+	for id, e := range self.data {
+		if now.After(e.deadline) || now.After(e.maxTime) {
+			delete(self.data, id)
+			self.expired[id] = &expiredEntry{
+				history: e.cmd.GetHistory(),
+				time:    now,
+			}
+			ret = append(ret, e.cmd)
+		}
+	}
+	for id, e := range self.expired {
+		if now.Sub(e.time) > EXPIRED_KEEP_TIME {
+			delete(self.expired, id)
+		}
+	}
+	return ret
+}
+
+func (self *CommandCache) checkExpire(interval time.Duration) {
+	tc := time.NewTicker(interval)
+	for now := range tc.C {
+		for _, c := range self.removeExpired(now) {
+			c.GetHistory().Add(&Output{Status: EXPIRED, Id: c.GetId(), Data: "command expired"})
+			c.Close()
+			if self.OnExpire != nil {
+				self.OnExpire(c)
+			}
+		}
+	}
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockCommand struct {
+	id      string
+	idle    time.Duration
+	max     time.Duration
+	history *History
+}
+
+func (p *mockCommand) GetMessageTimeout(time.Duration) (*Output, bool) { return nil, false }
+func (p *mockCommand) GetHistory() *History                            { return p.history }
+func (p *mockCommand) GetMessage() *Output                             { return nil }
+func (p *mockCommand) SetInputArgs(map[string]string)                  {}
+func (p *mockCommand) Finished() bool                                  { return false }
+func (p *mockCommand) Successed() bool                                 { return true }
+func (p *mockCommand) GetId() string                                   { return p.id }
+func (p *mockCommand) Close() bool                                     { return true }
+func (p *mockCommand) Expiry() (time.Duration, time.Duration)          { return p.idle, p.max }
+
+func TestCommandCacheExpire(t *testing.T) {
+	cache := &CommandCache{
+		data:    make(map[string]*cacheEntry),
+		expired: make(map[string]*expiredEntry),
+		lock:    &sync.RWMutex{},
+	}
+	a := &mockCommand{id: "a", idle: time.Second, max: 3 * time.Second, history: NewHistory()}
+	b := &mockCommand{id: "b", history: NewHistory()}
+	cache.SetCommand(a)
+	cache.SetCommand(b)
+
+	now := time.Now()
+	cache.Touch("a")
+	assert.Equal(t, 0, len(cache.removeExpired(now.Add(500*time.Millisecond))))
+	assert.Equal(t, []Command{a}, cache.removeExpired(now.Add(2*time.Second)))
+	assert.NotNil(t, cache.Expired("a"))
+	assert.Nil(t, cache.GetCommand("a"))
+
+	b.history.Add(&Output{Status: FINISH_FETCH_DATA})
+	cache.Delete("b")
+	assert.Nil(t, cache.GetCommand("b"))
+	assert.Equal(t, FINISH_FETCH_DATA, cache.Expired("b").Latest().Status)
+	assert.Equal(t, 0, len(cache.removeExpired(now.Add(time.Hour))))
+	assert.Nil(t, cache.Expired("b"))
+}
+
+func TestCommandCacheMaxLifetime(t *testing.T) {
+	cache := NewCommandCache()
+	a := &mockCommand{id: "a", idle: time.Hour, max: 2 * time.Second}
+	cache.SetCommand(a)
+	cache.Touch("a")
+	assert.Equal(t, []Command{a}, cache.removeExpired(time.Now().Add(3*time.Second)))
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_OUTPUT   = "output"
+	EVENT_PROGRESS = "progress"
+)
+
+// Event is either an Output of a command or a progress report of its steps.
+type Event struct {
+	Type   string  `json:"type"`
+	Output *Output `json:"output,omitempty"`
+	Step   int     `json:"step"`
+	Page   string  `json:"page,omitempty"`
+}
+
+// History keeps every Output emitted by a command, so that clients can read
+// them again without consuming them. It is also the message queue of the
+// command: Next consumes the outputs in order, and Add never blocks.
+type History struct {
+	messages []*Output
+	events   []*Event
+	cursor   int
+	pending  bool
+	closed   bool
+	update   chan struct{}
+	lock     *sync.Mutex
+}
+
+func NewHistory() *History {
+	return &History{
+		messages: []*Output{},
+		events:   []*Event{},
+		update:   make(chan struct{}),
+		lock:     &sync.Mutex{},
+	}
+}
+
+// notify wakes up all waiters, it must be called with lock held.
+func (p *History) notify() {
+	close(p.update)
+	p.update = make(chan struct{})
+}
+
+func (p *History) Add(o *Output) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.messages = append(p.messages, o)
+	p.events = append(p.events, &Event{Type: EVENT_OUTPUT, Output: o})
+	p.notify()
+}
+
+func (p *History) AddProgress(step int, page string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.events = append(p.events, &Event{Type: EVENT_PROGRESS, Step: step, Page: page})
+	p.notify()
+}
+
+// Close makes waiting Next return nil.
+func (p *History) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.notify()
+	}
+}
+
+func (p *History) Closed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.closed
+}
+
+func (p *History) Messages() []*Output {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*Output{}, p.messages...)
+}
+
+func (p *History) Latest() *Output {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.messages) == 0 {
+		return nil
+	}
+	return p.messages[len(p.messages)-1]
+}
+
+// waitFor blocks until ready returns true, the history is closed or timeout
+// is reached. A timeout <= 0 means no timeout. It returns false on timeout.
+func (p *History) waitFor(ready func() bool, timeout time.Duration) bool {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		p.lock.Lock()
+		ok, update := ready() || p.closed, p.update
+		p.lock.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-update:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// Next returns the first output not consumed yet. It returns nil, true when
+// the history is closed, and nil, false on timeout.
+func (p *History) Next(timeout time.Duration) (*Output, bool) {
+	if !p.waitFor(func() bool { return p.cursor < len(p.messages) }, timeout) {
+		return nil, false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.cursor >= len(p.messages) {
+		return nil, true
+	}
+	p.cursor++
+	return p.messages[p.cursor-1], true
+}
+
+// SetPending marks the outputs from now on as read by other means than Next,
+// since the caller got a PENDING output instead of the next one.
+func (p *History) SetPending() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pending = true
+}
+
+// SkipPending consumes the outputs left by Next after SetPending, so that Next
+// does not return an output the client already read as the answer to new
+// input.
+func (p *History) SkipPending() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pending {
+		p.cursor = len(p.messages)
+		p.pending = false
+	}
+}
+
+// Wait returns the messages once there are more than since of them, or when
+// timeout is reached.
+func (p *History) Wait(since int, timeout time.Duration) []*Output {
+	p.waitFor(func() bool { return len(p.messages) > since }, timeout)
+	return p.Messages()
+}
+
+// WaitEvents returns the events after the first since ones, waiting for them
+// at most timeout.
+func (p *History) WaitEvents(since int, timeout time.Duration) []*Event {
+	p.waitFor(func() bool { return len(p.events) > since }, timeout)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if since >= len(p.events) {
+		return []*Event{}
+	}
+	return append([]*Event{}, p.events[since:]...)
+}
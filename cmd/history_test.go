@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHistoryWait(t *testing.T) {
+	h := NewHistory()
+	assert.Nil(t, h.Latest())
+
+	start := time.Now()
+	assert.Equal(t, 0, len(h.Wait(0, 50*time.Millisecond)))
+	assert.True(t, time.Now().Sub(start) >= 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		h.Add(&Output{Status: LOGIN_SUCCESS})
+	}()
+	msgs := h.Wait(0, time.Minute)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, LOGIN_SUCCESS, h.Latest().Status)
+
+	h.Add(&Output{Status: FINISH_FETCH_DATA})
+	assert.Equal(t, 2, len(h.Wait(1, time.Minute)))
+}
+
+func TestHistoryNextAndEvents(t *testing.T) {
+	h := NewHistory()
+	_, ok := h.Next(10 * time.Millisecond)
+	assert.False(t, ok)
+
+	h.AddProgress(1, "login")
+	h.Add(&Output{Status: NEED_PARAM})
+	msg, ok := h.Next(time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, NEED_PARAM, msg.Status)
+
+	events := h.WaitEvents(0, time.Minute)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, EVENT_PROGRESS, events[0].Type)
+	assert.Equal(t, "login", events[0].Page)
+	assert.Equal(t, EVENT_OUTPUT, events[1].Type)
+
+	h.Close()
+	msg, ok = h.Next(time.Minute)
+	assert.Nil(t, msg)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(h.WaitEvents(2, time.Minute)))
+}
+
+func TestHistorySkipPending(t *testing.T) {
+	h := NewHistory()
+	h.SkipPending()
+	h.Add(&Output{Status: NEED_PARAM})
+	h.SetPending()
+	h.Add(&Output{Status: OUTPUT_VERIFYCODE})
+	h.SkipPending()
+	h.Add(&Output{Status: LOGIN_SUCCESS})
+	msg, _ := h.Next(time.Minute)
+	assert.Equal(t, LOGIN_SUCCESS, msg.Status)
+}
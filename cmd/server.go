@@ -5,40 +5,126 @@ import (
 	"fmt"
 	"github.com/BigTong/gocounter"
 	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cookie"
 	"net/http"
 	"net/url"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
 const (
 	kInternalErrorResut = "server get internal result"
 )
 
+const (
+	DEFAULT_MESSAGE_TIMEOUT = 5 * time.Minute
+	MAX_STATUS_WAIT         = 60 * time.Second
+	STREAM_HEARTBEAT        = 15 * time.Second
+)
+
 type CasperServer struct {
-	cmdCache   *CommandCache
-	ct         *gocounter.Counter
-	cmdFactory CommandFactory
+	cmdCache       *CommandCache
+	ct             *gocounter.Counter
+	cmdFactory     CommandFactory
+	store          SessionStore
+	messageTimeout time.Duration
+}
+
+type Status struct {
+	Id       string    `json:"id"`
+	Finished bool      `json:"finished"`
+	Latest   *Output   `json:"latest"`
+	History  []*Output `json:"history"`
 }
 
 func NewCasperServer(cf CommandFactory) *CasperServer {
 	ret := &CasperServer{
-		cmdCache:   NewCommandCache(),
-		ct:         gocounter.NewCounter(),
-		cmdFactory: cf,
+		cmdCache:       NewCommandCache(),
+		ct:             gocounter.NewCounter(),
+		cmdFactory:     cf,
+		messageTimeout: DEFAULT_MESSAGE_TIMEOUT,
 	}
+	ret.cmdCache.OnExpire = ret.expireCommand
 
 	return ret
 }
 
+func (self *CasperServer) expireCommand(c Command) {
+	dlog.Warn("command %s expired", c.GetId())
+	if self.store != nil {
+		if err := self.store.Delete(c.GetId()); err != nil {
+			dlog.Warn("delete session %s fail: %v", c.GetId(), err)
+		}
+	}
+}
+
+func (self *CasperServer) expiredOutput(id string) *Output {
+	return &Output{Status: EXPIRED, Id: id, Data: "command expired"}
+}
+
+// SetMessageTimeout sets how long Process waits for the next message, after
+// which it returns a pending output and the client should poll the status.
+func (self *CasperServer) SetMessageTimeout(timeout time.Duration) {
+	self.messageTimeout = timeout
+}
+
+// SetSessionStore makes Process restore commands missing in cache from store.
+// The commands themselves are saved to store by the CommandFactory.
+func (self *CasperServer) SetSessionStore(store SessionStore) {
+	self.store = store
+}
+
+func (self *CasperServer) restoreCommand(id string) Command {
+	restorer, ok := self.cmdFactory.(CommandRestorer)
+	if self.store == nil || !ok {
+		return nil
+	}
+	data, err := self.store.Load(id)
+	if err != nil {
+		if err != ErrSessionNotFound {
+			dlog.Warn("load session %s fail: %v", id, err)
+		}
+		return nil
+	}
+	c := restorer.RestoreCommand(data)
+	if c == nil {
+		return nil
+	}
+	self.cmdCache.SetCommand(c)
+	return c
+}
+
+func (self *CasperServer) deleteCommand(id string) {
+	self.cmdCache.Delete(id)
+	if self.store != nil {
+		if err := self.store.Delete(id); err != nil {
+			dlog.Warn("delete session %s fail: %v", id, err)
+		}
+	}
+}
+
+// setArgs sends the input of params to cmd and returns its next output. When
+// it returns PENDING instead, the client reads the outputs by Status or
+// ServeStream, so they are skipped at the next input rather than returned
+// stale.
 func (self *CasperServer) setArgs(cmd Command, params url.Values) *Output {
 	args := self.getArgs(params)
 	dlog.Println("setArgs:", args)
+	history := cmd.GetHistory()
+	history.SkipPending()
 	cmd.SetInputArgs(args)
+	if params.Get("async") == "true" {
+		history.SetPending()
+		return &Output{Status: PENDING, Id: cmd.GetId()}
+	}
 
-	if message := cmd.GetMessage(); message != nil {
-		return message
+	message, ok := cmd.GetMessageTimeout(self.messageTimeout)
+	if !ok {
+		history.SetPending()
+		return &Output{Status: PENDING, Id: cmd.GetId()}
 	}
-	return nil
+	return message
 }
 
 func (self *CasperServer) getArgs(params url.Values) map[string]string {
@@ -62,21 +148,162 @@ func (self *CasperServer) Process(params url.Values) *Output {
 		return self.setArgs(c, params)
 	}
 	c := self.cmdCache.GetCommand(id)
+	if history := self.cmdCache.Expired(id); c == nil && history != nil {
+		// the final output of a finished command, for clients which missed it
+		if latest := history.Latest(); latest != nil && IsFinalStatus(latest.Status) {
+			return latest
+		}
+		return self.expiredOutput(id)
+	}
+	if c == nil {
+		c = self.restoreCommand(id)
+	}
 	if c == nil {
 		dlog.Warn("get nil command id:%s", id)
 		return &Output{Status: FAIL, Data: "not get command"}
 	}
 
 	ret := self.setArgs(c, params)
+	if ret == nil {
+		// message channel is closed by expiry while waiting
+		return self.expiredOutput(id)
+	}
+	if ret.Status != FAIL {
+		self.cmdCache.Touch(id)
+	}
 
 	if c.Finished() || ret.Status == FAIL || ret.Status == FINISH_FETCH_DATA || ret.Status == FINISH_ALL {
 		c.Successed()
-		self.cmdCache.Delete(id)
+		self.deleteCommand(id)
 	}
 
 	return ret
 }
 
+// Status returns the messages of command id without consuming them. When wait
+// is positive it blocks until there are more than since messages or wait is
+// reached.
+func (self *CasperServer) Status(id string, since int, wait time.Duration) *Status {
+	ret := &Status{Id: id}
+	c := self.cmdCache.GetCommand(id)
+	var history *History
+	if c != nil {
+		history = c.GetHistory()
+	} else if history = self.cmdCache.Expired(id); history == nil {
+		ret.Latest = &Output{Status: FAIL, Id: id, Data: "not get command"}
+		return ret
+	}
+
+	if wait > MAX_STATUS_WAIT {
+		wait = MAX_STATUS_WAIT
+	}
+	if wait > 0 {
+		ret.History = history.Wait(since, wait)
+	} else {
+		ret.History = history.Messages()
+	}
+	if len(ret.History) > 0 {
+		ret.Latest = ret.History[len(ret.History)-1]
+	}
+	ret.Finished = c == nil || c.Finished()
+	return ret
+}
+
+func (self *CasperServer) ServeStatus(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	since, _ := strconv.Atoi(req.FormValue("since"))
+	wait, _ := strconv.Atoi(req.FormValue("wait"))
+	ret := self.Status(req.FormValue("id"), since, time.Duration(wait)*time.Second)
+	output, _ := json.Marshal(ret)
+	w.Header().Set("Content-Type", "application/json; encoding=UTF-8")
+	fmt.Fprint(w, string(output))
+}
+
+// ServeStream pushes every event of a command as server-sent events until its
+// final output. Clients resume with the Last-Event-ID header or the since
+// param, and should send their input by /submit with async=true.
+func (self *CasperServer) ServeStream(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	id := req.FormValue("id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	var history *History
+	if c := self.cmdCache.GetCommand(id); c != nil {
+		history = c.GetHistory()
+	} else if history = self.cmdCache.Expired(id); history == nil {
+		http.Error(w, "not get command", http.StatusNotFound)
+		return
+	}
+	since, _ := strconv.Atoi(req.FormValue("since"))
+	if last := req.Header.Get("Last-Event-ID"); len(last) > 0 {
+		since, _ = strconv.Atoi(last)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	for {
+		events := history.WaitEvents(since, STREAM_HEARTBEAT)
+		if len(events) == 0 {
+			if history.Closed() {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		for _, e := range events {
+			since++
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", since, e.Type, b)
+			if e.Type == EVENT_OUTPUT && IsFinalStatus(e.Output.Status) {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+		select {
+		case <-req.Context().Done():
+			return
+		default:
+		}
+	}
+}
+
+// ServeCookies exports the cookies of command id in format, json by default.
+// A POST first imports the cookie param, in format or detected when format is
+// empty, so that a session can go on in a browser or come back from it.
+func (self *CasperServer) ServeCookies(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	id := req.FormValue("id")
+	c := self.cmdCache.GetCommand(id)
+	if c == nil {
+		c = self.restoreCommand(id)
+	}
+	holder, ok := c.(CookieHolder)
+	if !ok {
+		http.Error(w, "not get command", http.StatusNotFound)
+		return
+	}
+	format := req.FormValue("format")
+	if req.Method == "POST" {
+		if err := holder.ImportCookies(format, []byte(req.FormValue("cookie"))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(format) == 0 {
+		format = cookie.FORMAT_JSON
+	}
+	b, err := holder.ExportCookies(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "%s", b)
+}
+
 func (self *CasperServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	defer func() {
 		if r := recover(); r != nil {
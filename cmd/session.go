@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"errors"
+	"gopkg.in/redis.v3"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps snapshots of commands, so that a command can be restored
+// after a restart or on another node.
+type SessionStore interface {
+	Save(id string, data []byte) error
+	Load(id string) ([]byte, error)
+	Delete(id string) error
+}
+
+// CommandRestorer is implemented by a CommandFactory which can restore a
+// command from the snapshot saved in a SessionStore.
+type CommandRestorer interface {
+	RestoreCommand(data []byte) Command
+}
+
+type FileSessionStore struct {
+	dir     string
+	timeout time.Duration
+}
+
+func NewFileSessionStore(dir string, timeout time.Duration) (*FileSessionStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{
+		dir:     dir,
+		timeout: timeout,
+	}, nil
+}
+
+func (p *FileSessionStore) path(id string) string {
+	return filepath.Join(p.dir, url.QueryEscape(id)+".json")
+}
+
+func (p *FileSessionStore) Save(id string, data []byte) error {
+	tmp := p.path(id) + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path(id))
+}
+
+func (p *FileSessionStore) Load(id string) ([]byte, error) {
+	info, err := os.Stat(p.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.timeout > 0 && time.Now().Sub(info.ModTime()) > p.timeout {
+		os.Remove(p.path(id))
+		return nil, ErrSessionNotFound
+	}
+	return ioutil.ReadFile(p.path(id))
+}
+
+func (p *FileSessionStore) Delete(id string) error {
+	err := os.Remove(p.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type RedisSessionStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisSessionStore(host string, timeout time.Duration) *RedisSessionStore {
+	return &RedisSessionStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:        host,
+			DialTimeout: time.Second * 5,
+		}),
+		timeout: timeout,
+	}
+}
+
+func (p *RedisSessionStore) key(id string) string {
+	return "session_" + id
+}
+
+func (p *RedisSessionStore) Save(id string, data []byte) error {
+	return p.client.Set(p.key(id), data, p.timeout).Err()
+}
+
+func (p *RedisSessionStore) Load(id string) ([]byte, error) {
+	b, err := p.client.Get(p.key(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	return b, err
+}
+
+func (p *RedisSessionStore) Delete(id string) error {
+	return p.client.Del(p.key(id)).Err()
+}
@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"github.com/xlvector/dlog"
 	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
 )
 
 type Redis struct {
@@ -39,6 +43,101 @@ type Flume struct {
 	Port int
 }
 
+// Session.Store is "file" or "redis", the redis store uses Redis.Host. Key
+// encrypts the credentials, context and cookies of the snapshots, without it
+// the credentials are not saved at all.
+type Session struct {
+	Store   string
+	Path    string
+	Timeout int64
+	Key     string
+}
+
+// Callback is where the final output of a command is posted. Secret signs
+// the body, and MaxRetry defaults to 5. AllowedUrls are the only urls a
+// caller may post to instead of Url, a caller url matches one with the same
+// scheme and host and a path under its path.
+type Callback struct {
+	Url         string
+	Secret      string
+	MaxRetry    int
+	AllowedUrls []string
+}
+
+func (p *Callback) allow(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	for _, s := range p.AllowedUrls {
+		a, err := url.Parse(s)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(u.Scheme, a.Scheme) && strings.EqualFold(u.Host, a.Host) &&
+			underPath(u.Path, a.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath tells whether the cleaned p is prefix or below it, /hook does
+// not allow /hook-evil nor /hook/../other.
+func underPath(p, prefix string) bool {
+	p = path.Clean("/" + p)
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// RateLimit paces requests to Rps with bursts of Burst, MaxSessions bounds
+// the commands of a template running at the same time. A command waiting for
+// a session, or more than a second for the pace, outputs a queued status.
+type RateLimit struct {
+	Rps         float64
+	Burst       int
+	MaxSessions int
+}
+
+// ClientProfile is the http client of the templates selecting it. Versions
+// are "1.0" to "1.3", CipherSuites are the names of crypto/tls, Pins are the
+// base64 sha256 of the subject public key info of an accepted certificate.
+// Timeouts are in seconds.
+type ClientProfile struct {
+	MinVersion            string
+	MaxVersion            string
+	CipherSuites          []string
+	InsecureSkipVerify    bool
+	RootCAs               []string
+	ClientCert            string
+	ClientKey             string
+	Pins                  []string
+	HTTP2                 bool
+	KeepAlive             bool
+	DialTimeout           int
+	TLSHandshakeTimeout   int
+	ResponseHeaderTimeout int
+	Timeout               int
+}
+
+// HeaderProfile is the default headers of the templates selecting it. A
+// command keeps one of UserAgents for its whole session, unless Rotate picks
+// a new one for each request. Rotate is ignored by templates running casperjs
+// so that casperjs and the downloader send the same user agent. Headers are
+// sent after the named ones and the headers of a step override them all.
+// Header order is not supported: net/http writes headers in its own order,
+// and casperjs only gets the user agent.
+type HeaderProfile struct {
+	UserAgents      []string
+	Rotate          bool
+	Accept          string
+	AcceptLanguage  string
+	SecChUa         string
+	SecChUaMobile   string
+	SecChUaPlatform string
+	Headers         map[string]string
+}
+
 type Config struct {
 	OutputRoot           string
 	Redis                Redis
@@ -50,6 +149,14 @@ type Config struct {
 	Buckets              map[string]string
 	UploadApi            string
 	SlackApi             string
+	RecordFixture        bool
+	Session              Session
+	Callbacks            map[string]*Callback
+	HostLimits           map[string]*RateLimit
+	TmplLimits           map[string]*RateLimit
+	ClientProfiles       map[string]*ClientProfile
+	HeaderProfiles       map[string]*HeaderProfile
+	AdminToken           string
 }
 
 func (p Config) HasRedis() bool {
@@ -66,23 +173,110 @@ func (p Config) HasFlume() bool {
 	return false
 }
 
-var Instance Config
+// instance is replaced as a whole by Reload while requests read it, so it is
+// only reached through Get and Set.
+var instance atomic.Value
+
+func init() {
+	instance.Store(&Config{})
+}
+
+// Get returns the current config, hold the pointer when several fields must
+// come from the same version. It must not be modified, use Set instead.
+func Get() *Config {
+	return instance.Load().(*Config)
+}
+
+// Set replaces the current config.
+func Set(c *Config) {
+	instance.Store(c)
+}
+
+var confFile string
 
 func Init(conf string) {
+	confFile = conf
 	b, err := ioutil.ReadFile(conf)
 	if err != nil {
 		dlog.Warn("fail to load config: %v", err)
 	}
-	err = json.Unmarshal(b, &Instance)
+	ret := &Config{}
+	err = json.Unmarshal(b, ret)
 	if err != nil {
 		dlog.Warn("fail to parse config: %v", err)
 	}
+	Set(ret)
+}
+
+// Reload reads the config file given to Init again, the config is kept when the
+// file can not be read or parsed.
+func Reload() error {
+	b, err := ioutil.ReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	ret := &Config{}
+	err = json.Unmarshal(b, ret)
+	if err != nil {
+		return err
+	}
+	Set(ret)
+	return nil
+}
+
+func File() string {
+	return confFile
+}
+
+// GetCallback returns the callback of tmpl, falling back to the _DEFAULT one.
+// A non empty link replaces the configured url when it is in AllowedUrls,
+// otherwise it is ignored so the secret never signs a body posted to it.
+func GetCallback(tmpl, link string) *Callback {
+	conf := Get()
+	cb, ok := conf.Callbacks[tmpl]
+	if !ok {
+		cb, ok = conf.Callbacks["_DEFAULT"]
+	}
+	ret := &Callback{}
+	if ok && cb != nil {
+		*ret = *cb
+	}
+	if len(link) > 0 {
+		if ret.allow(link) {
+			ret.Url = link
+		} else {
+			dlog.Warn("callback %s of %s is not allowed", link, tmpl)
+		}
+	}
+	if len(ret.Url) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// GetClientProfile returns the profile name, or the _DEFAULT one when name is
+// empty. It returns nil when there is no such profile.
+func GetClientProfile(name string) *ClientProfile {
+	if len(name) == 0 {
+		name = "_DEFAULT"
+	}
+	return Get().ClientProfiles[name]
+}
+
+// GetHeaderProfile returns the profile name, or the _DEFAULT one when name is
+// empty. It returns nil when there is no such profile.
+func GetHeaderProfile(name string) *HeaderProfile {
+	if len(name) == 0 {
+		name = "_DEFAULT"
+	}
+	return Get().HeaderProfiles[name]
 }
 
 func GetCookieTemplate(tmpl string) map[string]*CookieTemplate {
-	cookieTemplate := Instance.CookieTemplateConfig[tmpl]
+	conf := Get()
+	cookieTemplate := conf.CookieTemplateConfig[tmpl]
 	if resource, ok := cookieTemplate["_RESOURCE"]; ok {
-		cookieTemplate = Instance.CookieTemplateConfig[resource.Tmpl]
+		cookieTemplate = conf.CookieTemplateConfig[resource.Tmpl]
 	}
 	return cookieTemplate
 }
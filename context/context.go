@@ -266,6 +266,13 @@ func (p *Context) extractRegex(body, query string) interface{} {
 	return ret
 }
 
+// Validate checks that text parses as a template with the funcs of Context,
+// without executing it.
+func Validate(text string) error {
+	_, err := NewContext(nil, nil, nil).newEmptyTemplate().Parse(text)
+	return err
+}
+
 func (p *Context) Parse(text string) string {
 	t, err := p.newEmptyTemplate().Parse(text)
 	if err != nil {
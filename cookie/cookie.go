@@ -0,0 +1,141 @@
+package cookie
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_JAR       = "jar"
+	FORMAT_NETSCAPE  = "netscape"
+	FORMAT_PHANTOMJS = "phantomjs"
+	FORMAT_JSON      = "json"
+)
+
+// Cookie is the common form of the cookies of all formats. Domain has no
+// leading dot, HostOnly tells that the cookie is not sent to its subdomains.
+// Expires is zero for session cookies.
+type Cookie struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	HostOnly bool
+	Expires  time.Time
+}
+
+func newCookie(name, value, domain, path string) *Cookie {
+	if len(path) == 0 {
+		path = "/"
+	}
+	return &Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   strings.TrimPrefix(domain, "."),
+		Path:     path,
+		HostOnly: !strings.HasPrefix(domain, "."),
+	}
+}
+
+// dotDomain is the domain as written by browsers, with a leading dot unless
+// the cookie is host only.
+func (c *Cookie) dotDomain() string {
+	if c.HostOnly {
+		return c.Domain
+	}
+	return "." + c.Domain
+}
+
+func (c *Cookie) key() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+var parsers = map[string]func([]byte) ([]*Cookie, error){
+	FORMAT_JAR:       parseJar,
+	FORMAT_NETSCAPE:  parseNetscape,
+	FORMAT_PHANTOMJS: parsePhantomJS,
+	FORMAT_JSON:      parseJson,
+}
+
+var formatters = map[string]func([]*Cookie) ([]byte, error){
+	FORMAT_JAR:       formatJar,
+	FORMAT_NETSCAPE:  formatNetscape,
+	FORMAT_PHANTOMJS: formatPhantomJS,
+	FORMAT_JSON:      formatJson,
+}
+
+func SupportFormat(format string) bool {
+	_, ok := parsers[format]
+	return ok
+}
+
+// Detect guesses the format of b, it defaults to netscape.
+func Detect(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	switch {
+	case strings.HasPrefix(s, "[General]") || strings.Contains(s, "QNetworkCookie"):
+		return FORMAT_PHANTOMJS
+	case strings.HasPrefix(s, "{") || s == "null":
+		return FORMAT_JAR
+	case strings.HasPrefix(s, "["):
+		return FORMAT_JSON
+	}
+	return FORMAT_NETSCAPE
+}
+
+// Parse reads the cookies of b in format, an empty format is detected.
+func Parse(format string, b []byte) ([]*Cookie, error) {
+	if len(format) == 0 {
+		format = Detect(b)
+	}
+	parse, ok := parsers[format]
+	if !ok {
+		return nil, errors.New("unknown cookie format " + format)
+	}
+	return parse(b)
+}
+
+// Format writes cookies in format, later cookies replace former ones with the
+// same domain, path and name.
+func Format(format string, cookies []*Cookie) ([]byte, error) {
+	write, ok := formatters[format]
+	if !ok {
+		return nil, errors.New("unknown cookie format " + format)
+	}
+	return write(dedup(cookies))
+}
+
+func Convert(from, to string, b []byte) ([]byte, error) {
+	cookies, err := Parse(from, b)
+	if err != nil {
+		return nil, err
+	}
+	return Format(to, cookies)
+}
+
+func dedup(cookies []*Cookie) []*Cookie {
+	index := make(map[string]int)
+	ret := []*Cookie{}
+	for _, c := range cookies {
+		if len(c.Name) == 0 || len(c.Domain) == 0 {
+			continue
+		}
+		if i, ok := index[c.key()]; ok {
+			ret[i] = c
+			continue
+		}
+		index[c.key()] = len(ret)
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func sortCookies(cookies []*Cookie) {
+	sort.SliceStable(cookies, func(i, j int) bool {
+		return cookies[i].key() < cookies[j].key()
+	})
+}
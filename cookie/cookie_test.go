@@ -0,0 +1,83 @@
+package cookie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const phantomCookies = `[General]
+cookies="@Variant(\0\0\0\x7f\0\0\0\x16QList<QNetworkCookie>\0\0\0\0\0\x2\0\0\0RBAIDUID=ABC:FG=1; expires=Thu, 31-Dec-2037 23:55:55 GMT; domain=.baidu.com; path=/\0\0\0\x37sid=x1; secure; HttpOnly; domain=www.baidu.com; path=/s)"
+`
+
+func testCookies() []*Cookie {
+	return []*Cookie{
+		{Name: "BAIDUID", Value: "ABC:FG=1", Domain: "baidu.com", Path: "/",
+			Expires: time.Date(2037, 12, 31, 23, 55, 55, 0, time.UTC)},
+		{Name: "sid", Value: "x1", Domain: "www.baidu.com", Path: "/s", Secure: true, HttpOnly: true, HostOnly: true},
+	}
+}
+
+func TestPhantomJS(t *testing.T) {
+	assert.Equal(t, FORMAT_PHANTOMJS, Detect([]byte(phantomCookies)))
+	cookies, err := Parse("", []byte(phantomCookies))
+	assert.NoError(t, err)
+	assert.Equal(t, testCookies(), cookies)
+
+	// Qt 4 has no null flag before the list
+	qt4 := strings.Replace(phantomCookies, `QList<QNetworkCookie>\0\0`, `QList<QNetworkCookie>\0`, 1)
+	cookies, err = Parse(FORMAT_PHANTOMJS, []byte(qt4))
+	assert.NoError(t, err)
+	assert.Equal(t, testCookies(), cookies)
+
+	_, err = Parse(FORMAT_PHANTOMJS, []byte("[General]\ncookies=\"@Variant(\\0\\0\\0\\x7f)\""))
+	assert.Error(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FORMAT_JAR, FORMAT_NETSCAPE, FORMAT_PHANTOMJS, FORMAT_JSON} {
+		b, err := Format(format, testCookies())
+		assert.NoError(t, err, format)
+		assert.Equal(t, format, Detect(b), format)
+		cookies, err := Parse(format, b)
+		assert.NoError(t, err, format)
+		sortCookies(cookies)
+		assert.Equal(t, testCookies(), cookies, format)
+	}
+}
+
+func TestNetscape(t *testing.T) {
+	b, _ := Format(FORMAT_NETSCAPE, testCookies())
+	assert.Equal(t, NETSCAPE_HEADER+"\n"+
+		".baidu.com\tTRUE\t/\tFALSE\t2145916555\tBAIDUID\tABC:FG=1\n"+
+		"#HttpOnly_www.baidu.com\tFALSE\t/s\tTRUE\t0\tsid\tx1\n", string(b))
+
+	_, err := Parse(FORMAT_NETSCAPE, []byte("baidu.com\tTRUE\t/\n"))
+	assert.Error(t, err)
+}
+
+func TestJson(t *testing.T) {
+	// phantom.cookies of casperjs
+	cookies, err := Parse(FORMAT_JSON, []byte(`[{"domain":".baidu.com","expiry":2145916555,"httponly":false,"name":"BAIDUID","path":"/","secure":false,"value":"ABC:FG=1"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, testCookies()[:1], cookies)
+
+	// browser extensions
+	cookies, err = Parse("", []byte(`[{"domain":"www.baidu.com","hostOnly":true,"httpOnly":true,"name":"sid","path":"/s","secure":true,"session":true,"value":"x1"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, testCookies()[1:], cookies)
+}
+
+func TestFormatDedup(t *testing.T) {
+	cookies := testCookies()
+	newer := *cookies[0]
+	newer.Value = "DEF"
+	b, _ := Format(FORMAT_JSON, append(cookies, &newer, &Cookie{Name: "nodomain"}))
+	ret, _ := Parse(FORMAT_JSON, b)
+	assert.Equal(t, 2, len(ret))
+	assert.Equal(t, "DEF", ret[0].Value)
+
+	_, err := Format("har", cookies)
+	assert.Error(t, err)
+}
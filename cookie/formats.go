@@ -0,0 +1,209 @@
+package cookie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jarEntry is a cookie of persistent-cookiejar, whose file maps the eTLD+1 of
+// the domain to the entries keyed by domain;path;name.
+type jarEntry struct {
+	Name       string
+	Value      string
+	Domain     string
+	Path       string
+	Secure     bool
+	HttpOnly   bool
+	Persistent bool
+	HostOnly   bool
+	Expires    time.Time
+}
+
+func jarKey(domain string) string {
+	if key, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return key
+	}
+	return domain
+}
+
+func parseJar(b []byte) ([]*Cookie, error) {
+	sites := make(map[string]map[string]*jarEntry)
+	if err := json.Unmarshal(b, &sites); err != nil {
+		return nil, err
+	}
+	ret := []*Cookie{}
+	for _, entries := range sites {
+		for _, e := range entries {
+			if e == nil {
+				continue
+			}
+			c := newCookie(e.Name, e.Value, strings.TrimPrefix(e.Domain, "."), e.Path)
+			c.HostOnly = e.HostOnly
+			c.Secure = e.Secure
+			c.HttpOnly = e.HttpOnly
+			if e.Persistent {
+				c.Expires = e.Expires
+			}
+			ret = append(ret, c)
+		}
+	}
+	sortCookies(ret)
+	return ret, nil
+}
+
+func formatJar(cookies []*Cookie) ([]byte, error) {
+	sites := make(map[string]map[string]*jarEntry)
+	for _, c := range cookies {
+		site := jarKey(c.Domain)
+		if sites[site] == nil {
+			sites[site] = make(map[string]*jarEntry)
+		}
+		sites[site][c.key()] = &jarEntry{
+			Name:       c.Name,
+			Value:      c.Value,
+			Domain:     c.Domain,
+			Path:       c.Path,
+			Secure:     c.Secure,
+			HttpOnly:   c.HttpOnly,
+			Persistent: !c.Expires.IsZero(),
+			HostOnly:   c.HostOnly,
+			Expires:    c.Expires,
+		}
+	}
+	return json.Marshal(sites)
+}
+
+const (
+	NETSCAPE_HEADER   = "# Netscape HTTP Cookie File"
+	NETSCAPE_HTTPONLY = "#HttpOnly_"
+)
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// parseNetscape reads the cookies.txt of curl and wget, whose lines are the
+// tab separated domain, include subdomains, path, secure, expires, name and
+// value.
+func parseNetscape(b []byte) ([]*Cookie, error) {
+	ret := []*Cookie{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, NETSCAPE_HTTPONLY)
+		if httpOnly {
+			line = strings.TrimPrefix(line, NETSCAPE_HTTPONLY)
+		} else if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: want 7 fields, got %d", n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		c := newCookie(fields[5], fields[6], fields[0], fields[2])
+		c.HostOnly = fields[1] != "TRUE"
+		c.Secure = fields[3] == "TRUE"
+		c.HttpOnly = httpOnly
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0).UTC()
+		}
+		ret = append(ret, c)
+	}
+	return ret, scanner.Err()
+}
+
+func formatNetscape(cookies []*Cookie) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, NETSCAPE_HEADER)
+	for _, c := range cookies {
+		if c.HttpOnly {
+			buf.WriteString(NETSCAPE_HTTPONLY)
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", c.dotDomain(), netscapeBool(!c.HostOnly),
+			c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonCookie is a cookie exported by browser extensions, or by phantom.cookies
+// which has expiry instead of expirationDate. A domain without leading dot is
+// host only.
+type jsonCookie struct {
+	Name           string  `json:"name"`
+	Value          string  `json:"value"`
+	Domain         string  `json:"domain"`
+	Path           string  `json:"path"`
+	Secure         bool    `json:"secure"`
+	HttpOnly       bool    `json:"httpOnly"`
+	HostOnly       bool    `json:"hostOnly"`
+	Session        bool    `json:"session"`
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	Expiry         int64   `json:"expiry,omitempty"`
+}
+
+func parseJson(b []byte) ([]*Cookie, error) {
+	var cookies []*jsonCookie
+	if err := json.Unmarshal(b, &cookies); err != nil {
+		return nil, err
+	}
+	ret := []*Cookie{}
+	for _, jc := range cookies {
+		if jc == nil {
+			continue
+		}
+		c := newCookie(jc.Name, jc.Value, jc.Domain, jc.Path)
+		c.Secure = jc.Secure
+		c.HttpOnly = jc.HttpOnly
+		if jc.ExpirationDate > 0 && !jc.Session {
+			sec, frac := math.Modf(jc.ExpirationDate)
+			c.Expires = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+		} else if jc.Expiry > 0 {
+			c.Expires = time.Unix(jc.Expiry, 0).UTC()
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+func formatJson(cookies []*Cookie) ([]byte, error) {
+	ret := make([]*jsonCookie, 0, len(cookies))
+	for _, c := range cookies {
+		jc := &jsonCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.dotDomain(),
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			HostOnly: c.HostOnly,
+			Session:  c.Expires.IsZero(),
+		}
+		if !c.Expires.IsZero() {
+			jc.ExpirationDate = float64(c.Expires.Unix())
+			jc.Expiry = c.Expires.Unix()
+		}
+		ret = append(ret, jc)
+	}
+	return json.Marshal(ret)
+}
@@ -0,0 +1,223 @@
+package cookie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The cookies file of PhantomJS is a QSettings ini file, whose cookies key is
+// a QList<QNetworkCookie> QVariant holding the Set-Cookie form of each cookie.
+const (
+	PHANTOMJS_LIST_TYPE   = "QList<QNetworkCookie>"
+	PHANTOMJS_USER_TYPE   = 127
+	PHANTOMJS_TIME_FORMAT = "Mon, 02-Jan-2006 15:04:05 GMT"
+)
+
+var iniEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+// unescapeIni reverses the escaping of QSettings, octal and hex escapes take
+// all the digits following them.
+func unescapeIni(s string) []byte {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	ret := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			ret = append(ret, s[i])
+			continue
+		}
+		i++
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '7':
+			v := 0
+			for ; i < len(s) && s[i] >= '0' && s[i] <= '7'; i++ {
+				v = v*8 + int(s[i]-'0')
+			}
+			i--
+			ret = append(ret, byte(v))
+		case c == 'x' && i+1 < len(s) && isHexDigit(s[i+1]):
+			v := 0
+			for i++; i < len(s) && isHexDigit(s[i]); i++ {
+				v = v*16 + hexValue(s[i])
+			}
+			i--
+			ret = append(ret, byte(v))
+		case iniEscapes[c] != 0:
+			ret = append(ret, iniEscapes[c])
+		default:
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// escapeIni escapes b like QSettings, a digit after a numeric escape is
+// escaped too so that it is not read as part of it.
+func escapeIni(b []byte) string {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('"')
+	escapeDigit := false
+	for _, c := range b {
+		switch {
+		case c == 0:
+			buf.WriteString(`\0`)
+			escapeDigit = true
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+			escapeDigit = false
+		case c < 0x20 || c >= 0x7f || (escapeDigit && isHexDigit(c)):
+			fmt.Fprintf(buf, `\x%x`, c)
+			escapeDigit = true
+		default:
+			buf.WriteByte(c)
+			escapeDigit = false
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// readList reads a QList of QByteArray which must fill data.
+func readList(data []byte) ([][]byte, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	ret := [][]byte{}
+	for i := uint32(0); i < n; i++ {
+		if len(data) < 4 {
+			return nil, false
+		}
+		size := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if size == 0xffffffff {
+			continue
+		}
+		if uint32(len(data)) < size {
+			return nil, false
+		}
+		ret = append(ret, data[:size])
+		data = data[size:]
+	}
+	return ret, len(data) == 0
+}
+
+func readVariant(data []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(data, []byte("@Variant(")) || !bytes.HasSuffix(data, []byte(")")) {
+		return nil, errors.New("cookies is not a variant")
+	}
+	data = data[len("@Variant(") : len(data)-1]
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != PHANTOMJS_USER_TYPE {
+		return nil, errors.New("cookies is not a user type")
+	}
+	size := binary.BigEndian.Uint32(data[4:])
+	data = data[8:]
+	if uint32(len(data)) < size || !bytes.HasPrefix(data, []byte(PHANTOMJS_LIST_TYPE)) {
+		return nil, errors.New("cookies is not a " + PHANTOMJS_LIST_TYPE)
+	}
+	data = data[size:]
+	// Qt 5 writes a null flag before the value, Qt 4 does not
+	if len(data) > 0 && data[0] == 0 {
+		if ret, ok := readList(data[1:]); ok {
+			return ret, nil
+		}
+	}
+	if ret, ok := readList(data); ok {
+		return ret, nil
+	}
+	return nil, errors.New("bad " + PHANTOMJS_LIST_TYPE)
+}
+
+func parsePhantomJS(b []byte) ([]*Cookie, error) {
+	var value string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), len(b)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "cookies=") {
+			value = strings.TrimPrefix(line, "cookies=")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	ret := []*Cookie{}
+	if len(value) == 0 {
+		return ret, nil
+	}
+	raws, err := readVariant(unescapeIni(value))
+	if err != nil {
+		return nil, err
+	}
+	for _, raw := range raws {
+		resp := &http.Response{Header: http.Header{"Set-Cookie": {string(raw)}}}
+		for _, hc := range resp.Cookies() {
+			c := newCookie(hc.Name, hc.Value, hc.Domain, hc.Path)
+			c.Secure = hc.Secure
+			c.HttpOnly = hc.HttpOnly
+			if !hc.Expires.IsZero() {
+				c.Expires = hc.Expires.UTC()
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+func rawCookie(c *Cookie) string {
+	ret := c.Name + "=" + c.Value
+	if c.Secure {
+		ret += "; secure"
+	}
+	if c.HttpOnly {
+		ret += "; HttpOnly"
+	}
+	if !c.Expires.IsZero() {
+		ret += "; expires=" + c.Expires.UTC().Format(PHANTOMJS_TIME_FORMAT)
+	}
+	return ret + "; domain=" + c.dotDomain() + "; path=" + c.Path
+}
+
+func formatPhantomJS(cookies []*Cookie) ([]byte, error) {
+	data := &bytes.Buffer{}
+	data.WriteString("@Variant(")
+	binary.Write(data, binary.BigEndian, uint32(PHANTOMJS_USER_TYPE))
+	binary.Write(data, binary.BigEndian, uint32(len(PHANTOMJS_LIST_TYPE)+1))
+	data.WriteString(PHANTOMJS_LIST_TYPE)
+	data.WriteByte(0)
+	data.WriteByte(0)
+	binary.Write(data, binary.BigEndian, uint32(len(cookies)))
+	for _, c := range cookies {
+		raw := rawCookie(c)
+		binary.Write(data, binary.BigEndian, uint32(len(raw)))
+		data.WriteString(raw)
+	}
+	data.WriteString(")")
+	return []byte("[General]\ncookies=" + escapeIni(data.Bytes()) + "\n"), nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xmlquery"
 	"github.com/xlvector/dlog"
 	"github.com/xlvector/higgs/jsonpath"
 	"strings"
@@ -16,6 +17,23 @@ type Context interface {
 	Set(string, interface{})
 }
 
+var docTypes = map[string]bool{
+	"":      true,
+	"html":  true,
+	"json":  true,
+	"jsonp": true,
+	"regex": true,
+	"xml":   true,
+	"csv":   true,
+	"tsv":   true,
+	"xlsx":  true,
+	"pdf":   true,
+}
+
+func SupportDocType(docType string) bool {
+	return docTypes[docType]
+}
+
 func guessDocType(body []byte) string {
 	buf := strings.TrimSpace(string(body))
 	if len(buf) == 0 {
@@ -29,6 +47,10 @@ func guessDocType(body []byte) string {
 		return "json"
 	}
 
+	if isSoap(buf) || strings.HasPrefix(buf, "<?xml") && !strings.Contains(buf, "<html") {
+		return "xml"
+	}
+
 	if strings.Contains(buf, "<html") && strings.Contains(buf, "</html>") {
 		return "html"
 	}
@@ -69,6 +91,24 @@ func Extract(body []byte, config0 interface{}, docType string, c Context) (inter
 		return Extract([]byte(jsonp), config, "json", c)
 	} else if docType == "regex" {
 		return textExtract(string(body), config, c), nil
+	} else if docType == "xml" {
+		doc, err := parseXml(body)
+		if err != nil {
+			return nil, err
+		}
+		return xmlExtract([]*xmlquery.Node{doc}, config, docNamespaces(doc), c), nil
+	} else if docType == "csv" {
+		return tableExtract(csvSource(body, ','), "", config, c), nil
+	} else if docType == "tsv" {
+		return tableExtract(csvSource(body, '\t'), "", config, c), nil
+	} else if docType == "xlsx" {
+		return xlsxExtract(body, config, c)
+	} else if docType == "pdf" {
+		text, err := pdfText(body)
+		if err != nil {
+			return nil, err
+		}
+		return textExtract(text, config, c), nil
 	} else {
 		return nil, errors.New("does not support doc type: " + docType)
 	}
@@ -143,6 +183,9 @@ func jsonExtract(j *jsonpath.Json, config interface{}, c Context) interface{} {
 	}
 
 	if m, ok := config.(map[string]interface{}); ok {
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(jsonExtract(j, q, c), c)
+		}
 		root := jsonpath.GetString(m, "_root")
 		rj := j.Data()
 		if len(root) > 0 {
@@ -207,6 +250,9 @@ func textExtract(text string, config interface{}, c Context) interface{} {
 		return regexQuery(text, v, c)
 	}
 	if m, ok := config.(map[string]interface{}); ok {
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(textExtract(text, q, c), c)
+		}
 		ret := make(map[string]interface{})
 		for k, v := range m {
 			key := k
@@ -247,10 +293,13 @@ func htmlExtract(s *goquery.Selection, config interface{}, c Context) interface{
 	}
 
 	if m, ok := config.(map[string]interface{}); ok {
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(htmlExtract(s, q, c), c)
+		}
 		root := jsonpath.GetString(m, "_root")
 		rs := s
 		if len(root) > 0 {
-			rs = s.Find(root)
+			rs = find(s, root)
 			if rs.Size() == 0 {
 				return nil
 			}
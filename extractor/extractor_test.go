@@ -246,3 +246,139 @@ func TestTaobaoHdc(t *testing.T) {
     `
 	assert.Equal(t, "2012-09-15", regexExtract(body, ">([\\d]{4}-[\\d]{2}-[\\d]{2})</span>"))
 }
+
+func TestXPathExtractor(t *testing.T) {
+	html := `
+        <html>
+            <body>
+                <table id="info">
+                    <tr><th>姓名</th><td>Liang Xiang</td></tr>
+                    <tr><th>证件</th><td><a href="/id/1">1101</a></td></tr>
+                    <tr><th>余额</th><td class="amount"> 12.5 </td></tr>
+                </table>
+                <ul><li>a</li><li>b</li><li>c</li></ul>
+            </body>
+        </html>
+    `
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "name": "xpath://th[text()='姓名']/following-sibling::td",
+            "link": "xpath://th[.='证件']/../td/a/@href&prefix=http://a.com",
+            "id": "xpath://a[contains(@href, '/id/')]/text()",
+            "amount": "xpath:normalize-space(//td[@class='amount'])",
+            "rows": "xpath:count(//tr)",
+            "items": "xpath://li",
+            "missing": "xpath://div",
+            "mixed": {
+                "_root": "xpath://table[@id='info']",
+                "first": "tr:first-child td",
+                "last": "xpath:.//tr[last()]/th"
+            },
+            "list": {
+                "_root": "xpath://tr[td]",
+                "_array": true,
+                "key": "xpath:./th",
+                "value": "td"
+            }
+        }
+    `), &config)
+	ret, err := Extract([]byte(html), config, "html", nil)
+	assert.NoError(t, err)
+	m := ret.(map[string]interface{})
+	assert.Equal(t, "Liang Xiang", m["name"])
+	assert.Equal(t, "http://a.com/id/1", m["link"])
+	assert.Equal(t, "1101", m["id"])
+	assert.Equal(t, "12.5", m["amount"])
+	assert.Equal(t, "3", m["rows"])
+	assert.Equal(t, []string{"a", "b", "c"}, m["items"])
+	assert.Nil(t, m["missing"])
+	assert.Equal(t, map[string]interface{}{"first": "Liang Xiang", "last": "余额"}, m["mixed"])
+	list := m["list"].([]interface{})
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, map[string]interface{}{"key": "证件", "value": "1101"}, list[1])
+}
+
+func TestXmlExtractor(t *testing.T) {
+	body := `<?xml version="1.0" encoding="GBK"?>
+<bill no="B01">
+    <owner>张三</owner>
+    <item id="1"><name>water</name><fee>10.5</fee></item>
+    <item id="2"><name>power</name><fee>20</fee></item>
+</bill>`
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "no": "/bill&attr=no",
+            "owner": "//owner",
+            "total": "sum(//fee)",
+            "names": "xpath://item/name",
+            "items": {
+                "_root": "//item",
+                "_array": true,
+                "id": ":this&attr=id",
+                "fee": "fee"
+            }
+        }
+    `), &config)
+	ret, err := Extract([]byte(body), config, "xml", nil)
+	assert.NoError(t, err)
+	m := ret.(map[string]interface{})
+	assert.Equal(t, "B01", m["no"])
+	assert.Equal(t, "张三", m["owner"])
+	assert.Equal(t, "30.500000", m["total"])
+	assert.Equal(t, []string{"water", "power"}, m["names"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": "1", "fee": "10.5"},
+		map[string]interface{}{"id": "2", "fee": "20"},
+	}, m["items"])
+}
+
+func TestSoapExtractor(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+    <SOAP-ENV:Body>
+        <ns1:QueryResponse xmlns:ns1="urn:utility:bill">
+            <ns1:account>A-100</ns1:account>
+            <ns1:bills>
+                <ns1:bill month="2016-01"><ns1:fee>30</ns1:fee></ns1:bill>
+                <ns1:bill month="2016-02"><ns1:fee>42</ns1:fee></ns1:bill>
+            </ns1:bills>
+        </ns1:QueryResponse>
+    </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+	assert.Equal(t, "xml", guessDocType([]byte(body)))
+	assert.Equal(t, "xml", guessDocType([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body/></s:Envelope>`)))
+	assert.Equal(t, "html", guessDocType([]byte(`<?xml version="1.0"?><html><body></body></html>`)))
+	assert.Equal(t, "html", guessDocType([]byte(`<html><body>a soap:Envelope of http://schemas.xmlsoap.org/soap/envelope/</body></html>`)))
+
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "_ns": {"b": "urn:utility:bill"},
+            "_root": "/soap:Envelope/soap:Body/b:QueryResponse",
+            "account": "b:account",
+            "same_prefix": "ns1:account",
+            "any_ns": "//*[local-name()='bill'][1]/*[local-name()='fee']",
+            "bills": {
+                "_root": "b:bills/b:bill",
+                "_array": true,
+                "month": ":this&attr=month",
+                "fee": "b:fee"
+            }
+        }
+    `), &config)
+	ret, err := Extract([]byte(body), config, "", nil)
+	assert.NoError(t, err)
+	m := ret.(map[string]interface{})
+	assert.Equal(t, "A-100", m["account"])
+	assert.Equal(t, "A-100", m["same_prefix"])
+	assert.Equal(t, "30", m["any_ns"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"month": "2016-01", "fee": "30"},
+		map[string]interface{}{"month": "2016-02", "fee": "42"},
+	}, m["bills"])
+
+	ret, _ = Extract([]byte(body), map[string]interface{}{"x": "x:account"}, "xml", nil)
+	assert.Equal(t, map[string]interface{}{"x": nil}, ret)
+}
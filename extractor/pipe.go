@@ -0,0 +1,467 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xlvector/dlog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A map config with _pipe is the value of its _query passed through a chain of
+// typed transforms, e.g. {"_query": "td.amount", "_pipe": ["trim", "number"]}.
+// A transform is "name", "name:arg" or {"name": arg}, and applies to each
+// element of an array except join. Unlike the "||" template of a query, the
+// transforms keep numbers as numbers in the result.
+const (
+	QUERY_KEY = "_query"
+	PIPE_KEY  = "_pipe"
+)
+
+// DEFAULT_BASE_URL is the template of the url the url transform resolves
+// links against, the page of the step.
+const DEFAULT_BASE_URL = "{{if ._url}}{{._url}}{{end}}"
+
+type transform struct {
+	fn   func(v interface{}, c Context) interface{}
+	each bool
+}
+
+type pipe []*transform
+
+var transforms = map[string]func(arg interface{}) (*transform, error){
+	"trim":      newTrim,
+	"lowercase": newLowercase,
+	"number":    newNumber,
+	"date":      newDate,
+	"currency":  newCurrency,
+	"split":     newSplit,
+	"join":      newJoin,
+	"map":       newMap,
+	"url":       newUrl,
+}
+
+func newPipe(spec interface{}) (pipe, error) {
+	specs, ok := spec.([]interface{})
+	if !ok {
+		specs = []interface{}{spec}
+	}
+	ret := make(pipe, 0, len(specs))
+	for _, s := range specs {
+		var name string
+		var arg interface{}
+		switch v := s.(type) {
+		case string:
+			tks := strings.SplitN(v, ":", 2)
+			name = tks[0]
+			if len(tks) == 2 {
+				arg = tks[1]
+			}
+		case map[string]interface{}:
+			if len(v) != 1 {
+				return nil, errors.New("transform should map its name to its argument")
+			}
+			for k, a := range v {
+				name, arg = k, a
+			}
+		default:
+			return nil, fmt.Errorf("bad transform %v", s)
+		}
+		build, ok := transforms[name]
+		if !ok {
+			return nil, errors.New("unknown transform " + name)
+		}
+		t, err := build(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+// pipeQuery returns the query and the pipe of m if it has _pipe.
+func pipeQuery(m map[string]interface{}) (interface{}, pipe, bool) {
+	spec, ok := m[PIPE_KEY]
+	if !ok {
+		return nil, nil, false
+	}
+	p, err := newPipe(spec)
+	if err != nil {
+		dlog.Warn("pipe %v error: %v", spec, err)
+	}
+	return m[QUERY_KEY], p, true
+}
+
+func (p pipe) apply(v interface{}, c Context) interface{} {
+	for _, t := range p {
+		v = t.apply(v, c)
+	}
+	return v
+}
+
+func (t *transform) apply(v interface{}, c Context) interface{} {
+	if t.each {
+		switch vs := v.(type) {
+		case []string:
+			ret := make([]interface{}, 0, len(vs))
+			for _, e := range vs {
+				ret = append(ret, t.fn(e, c))
+			}
+			return ret
+		case []interface{}:
+			ret := make([]interface{}, 0, len(vs))
+			for _, e := range vs {
+				ret = append(ret, t.apply(e, c))
+			}
+			return ret
+		}
+	}
+	return t.fn(v, c)
+}
+
+// ValidatePipes checks the _pipe of every map in config.
+func ValidatePipes(config interface{}) error {
+	return validatePipes("", config)
+}
+
+func validatePipes(path string, config interface{}) error {
+	switch v := config.(type) {
+	case map[string]interface{}:
+		if spec, ok := v[PIPE_KEY]; ok {
+			if _, ok := v[QUERY_KEY]; !ok {
+				return fmt.Errorf("%s: _pipe without _query", path)
+			}
+			if _, err := newPipe(spec); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == PIPE_KEY {
+				continue
+			}
+			sub := k
+			if len(path) > 0 {
+				sub = path + "." + k
+			}
+			if err := validatePipes(sub, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if err := validatePipes(fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func stringValue(v interface{}) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	return formatValue(v), true
+}
+
+func stringArg(arg interface{}, def string) (string, error) {
+	if arg == nil {
+		return def, nil
+	}
+	if s, ok := arg.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("argument %v is not a string", arg)
+}
+
+func stringTransform(f func(s string, c Context) interface{}) *transform {
+	return &transform{each: true, fn: func(v interface{}, c Context) interface{} {
+		s, ok := stringValue(v)
+		if !ok {
+			return nil
+		}
+		return f(s, c)
+	}}
+}
+
+// newTrim trims the spaces and the characters of arg.
+func newTrim(arg interface{}) (*transform, error) {
+	cutset, err := stringArg(arg, "")
+	if err != nil {
+		return nil, err
+	}
+	return stringTransform(func(s string, c Context) interface{} {
+		return strings.Trim(s, " \t\r\n\u00a0"+cutset)
+	}), nil
+}
+
+func newLowercase(arg interface{}) (*transform, error) {
+	return stringTransform(func(s string, c Context) interface{} {
+		return strings.ToLower(s)
+	}), nil
+}
+
+// numberLocales are the group and decimal separators of numbers by locale.
+var numberLocales = map[string][2]string{
+	"":   {",", "."},
+	"en": {",", "."},
+	"zh": {",", "."},
+	"ja": {",", "."},
+	"de": {".", ","},
+	"es": {".", ","},
+	"it": {".", ","},
+	"fr": {" \u00a0\u202f", ","},
+	"ru": {" \u00a0\u202f", ","},
+}
+
+// numberUnits multiply the number they follow.
+var numberUnits = []struct {
+	unit string
+	mul  float64
+}{
+	{"亿", 1e8},
+	{"万", 1e4},
+}
+
+// newNumber parses the first number of the value, like 1234.56 of
+// "1,234.56元" or -8000 of "(8,000)", with the separators of the locale arg.
+func newNumber(arg interface{}) (*transform, error) {
+	locale, err := stringArg(arg, "")
+	if err != nil {
+		return nil, err
+	}
+	seps, ok := numberLocales[strings.ToLower(locale)]
+	if !ok {
+		return nil, errors.New("unknown locale " + locale)
+	}
+	group, decimal := regexp.QuoteMeta(seps[0]), regexp.QuoteMeta(seps[1])
+	reg := regexp.MustCompile(`[0-9][0-9` + group + `]*(` + decimal + `[0-9]+)?`)
+	return &transform{each: true, fn: func(v interface{}, c Context) interface{} {
+		if f, ok := v.(float64); ok {
+			return f
+		}
+		s, ok := stringValue(v)
+		if !ok {
+			return nil
+		}
+		loc := reg.FindStringIndex(s)
+		if loc == nil {
+			return nil
+		}
+		num := s[loc[0]:loc[1]]
+		for _, g := range seps[0] {
+			num = strings.Replace(num, string(g), "", -1)
+		}
+		num = strings.Replace(num, seps[1], ".", 1)
+		ret, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil
+		}
+		prefix, suffix := strings.TrimSpace(s[:loc[0]]), strings.TrimSpace(s[loc[1]:])
+		for _, u := range numberUnits {
+			if strings.HasPrefix(suffix, u.unit) {
+				ret *= u.mul
+				break
+			}
+		}
+		sign := strings.TrimRight(prefix, " ¥￥$€£")
+		if strings.HasPrefix(prefix, "-") || strings.HasPrefix(prefix, "−") ||
+			strings.HasSuffix(sign, "-") || strings.HasSuffix(sign, "−") ||
+			strings.HasPrefix(prefix, "(") && strings.HasSuffix(suffix, ")") {
+			ret = -ret
+		}
+		return ret
+	}}, nil
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-1-2 15:04:05",
+	"2006-1-2 15:04",
+	"2006-1-2T15:04:05",
+	"2006-1-2",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+	"2006.1.2",
+	"2006年1月2日 15:04:05",
+	"2006年1月2日 15:04",
+	"2006年1月2日",
+	"20060102150405",
+	"20060102",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Jan 2, 2006",
+	"2 Jan 2006",
+}
+
+// isoLayout returns the ISO 8601 layout with the fields of layout: the date,
+// the time and the zone.
+func isoLayout(layout string) string {
+	if strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST") {
+		return time.RFC3339
+	}
+	if strings.Contains(layout, "15") || strings.Contains(layout, "03") || strings.Contains(layout, "3:") {
+		return "2006-01-02T15:04:05"
+	}
+	return "2006-01-02"
+}
+
+// newDate formats the date of the value in ISO 8601, parsing it with the
+// layout or the list of layouts of arg, or with dateLayouts.
+func newDate(arg interface{}) (*transform, error) {
+	layouts := dateLayouts
+	switch v := arg.(type) {
+	case nil:
+	case string:
+		layouts = []string{v}
+	case []interface{}:
+		layouts = nil
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("layout %v is not a string", e)
+			}
+			layouts = append(layouts, s)
+		}
+	default:
+		return nil, fmt.Errorf("argument %v is not a layout", arg)
+	}
+	return stringTransform(func(s string, c Context) interface{} {
+		s = strings.TrimSpace(s)
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t.Format(isoLayout(layout))
+			}
+		}
+		return nil
+	}), nil
+}
+
+// currencies map the codes, symbols and names of currencies to their ISO 4217
+// code, longer ones first.
+var currencies = [][2]string{
+	{"CNY", "CNY"}, {"USD", "USD"}, {"HKD", "HKD"}, {"EUR", "EUR"},
+	{"GBP", "GBP"}, {"JPY", "JPY"}, {"TWD", "TWD"}, {"MOP", "MOP"}, {"RMB", "CNY"},
+	{"HK$", "HKD"}, {"US$", "USD"}, {"NT$", "TWD"},
+	{"人民币", "CNY"}, {"港币", "HKD"}, {"港元", "HKD"}, {"美元", "USD"},
+	{"新台币", "TWD"}, {"新台幣", "TWD"}, {"新臺幣", "TWD"}, {"台币", "TWD"}, {"台幣", "TWD"},
+	{"澳门币", "MOP"}, {"澳門幣", "MOP"}, {"澳门元", "MOP"}, {"澳門元", "MOP"},
+	{"欧元", "EUR"}, {"英镑", "GBP"}, {"日元", "JPY"}, {"円", "JPY"},
+	{"元", "CNY"}, {"¥", "CNY"}, {"￥", "CNY"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"},
+}
+
+// newCurrency gives the ISO 4217 code of the currency in the value, or arg
+// when there is none.
+func newCurrency(arg interface{}) (*transform, error) {
+	def, err := stringArg(arg, "")
+	if err != nil {
+		return nil, err
+	}
+	return stringTransform(func(s string, c Context) interface{} {
+		s = strings.ToUpper(s)
+		for _, cur := range currencies {
+			if strings.Contains(s, cur[0]) {
+				return cur[1]
+			}
+		}
+		if len(def) > 0 {
+			return def
+		}
+		return nil
+	}), nil
+}
+
+// newSplit splits the value by arg, "," by default, into its non empty parts.
+func newSplit(arg interface{}) (*transform, error) {
+	sep, err := stringArg(arg, ",")
+	if err != nil {
+		return nil, err
+	}
+	return stringTransform(func(s string, c Context) interface{} {
+		ret := []interface{}{}
+		for _, tk := range strings.Split(s, sep) {
+			if tk = strings.TrimSpace(tk); len(tk) > 0 {
+				ret = append(ret, tk)
+			}
+		}
+		return ret
+	}), nil
+}
+
+// newJoin joins the elements of an array with arg.
+func newJoin(arg interface{}) (*transform, error) {
+	sep, err := stringArg(arg, "")
+	if err != nil {
+		return nil, err
+	}
+	return &transform{fn: func(v interface{}, c Context) interface{} {
+		var tks []string
+		switch vs := v.(type) {
+		case []string:
+			tks = vs
+		case []interface{}:
+			for _, e := range vs {
+				if s, ok := stringValue(e); ok {
+					tks = append(tks, s)
+				}
+			}
+		default:
+			return v
+		}
+		return strings.Join(tks, sep)
+	}}, nil
+}
+
+// newMap looks the value up in the map arg, values not in it are unchanged.
+func newMap(arg interface{}) (*transform, error) {
+	m, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("argument should be a map")
+	}
+	return &transform{each: true, fn: func(v interface{}, c Context) interface{} {
+		s, ok := stringValue(v)
+		if !ok {
+			return nil
+		}
+		if ret, ok := m[strings.TrimSpace(s)]; ok {
+			return ret
+		}
+		return v
+	}}, nil
+}
+
+// newUrl resolves the value against the url of the template arg, the page of
+// the step by default.
+func newUrl(arg interface{}) (*transform, error) {
+	base, err := stringArg(arg, DEFAULT_BASE_URL)
+	if err != nil {
+		return nil, err
+	}
+	return stringTransform(func(s string, c Context) interface{} {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			return s
+		}
+		b, err := url.Parse(parse(base, c))
+		if err != nil || !b.IsAbs() {
+			return s
+		}
+		ref, err := url.Parse(s)
+		if err != nil {
+			return s
+		}
+		return b.ResolveReference(ref).String()
+	}), nil
+}
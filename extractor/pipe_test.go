@@ -0,0 +1,121 @@
+package extractor
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type urlContext string
+
+func (c urlContext) Parse(s string) string {
+	if s == DEFAULT_BASE_URL {
+		return string(c)
+	}
+	return s
+}
+
+func (c urlContext) Set(string, interface{}) {}
+
+func TestPipe(t *testing.T) {
+	apply := func(spec interface{}, v interface{}) interface{} {
+		p, err := newPipe(spec)
+		assert.NoError(t, err)
+		return p.apply(v, urlContext("http://a.com/list/1.html?p=2"))
+	}
+	assert.Equal(t, "a b", apply("trim", " a b\n"))
+	assert.Equal(t, "a b", apply("trim:*", " **a b* "))
+	assert.Equal(t, "abc", apply("lowercase", "AbC"))
+
+	assert.Equal(t, 1234.56, apply("number", "1,234.56元"))
+	assert.Equal(t, -8000.0, apply("number", "(8,000)"))
+	assert.Equal(t, -120.5, apply("number", "¥ -120.50"))
+	assert.Equal(t, -120.5, apply("number", "-¥120.50"))
+	assert.Equal(t, 12000.0, apply("number", "1.2万元"))
+	assert.Equal(t, 1234.56, apply("number:de", "1.234,56 €"))
+	assert.Equal(t, 1234.56, apply("number:fr", "1 234,56 €"))
+	assert.Nil(t, apply("number", "无"))
+	assert.Nil(t, apply("number", nil))
+
+	assert.Equal(t, "2016-01-02", apply("date", "2016年1月2日"))
+	assert.Equal(t, "2016-01-02", apply("date", "20160102"))
+	assert.Equal(t, "2016-01-02T08:05:00", apply("date", "2016/01/02 08:05"))
+	assert.Equal(t, "2016-01-02T08:05:00+08:00", apply("date", "Sat, 02 Jan 2016 08:05:00 +0800"))
+	assert.Equal(t, "2016-01-02", apply(map[string]interface{}{"date": "02/01/2006"}, "02/01/2016"))
+	assert.Nil(t, apply("date", "yesterday"))
+
+	assert.Equal(t, "CNY", apply("currency", "1,234.56元"))
+	assert.Equal(t, "HKD", apply("currency", "港元"))
+	assert.Equal(t, "USD", apply("currency", "US$ 12"))
+	assert.Equal(t, "TWD", apply("currency", "NT$1,000"))
+	assert.Equal(t, "TWD", apply("currency", "新臺幣 1,000 元"))
+	assert.Equal(t, "TWD", apply("currency", "台幣500"))
+	assert.Equal(t, "MOP", apply("currency", "MOP$ 80"))
+	assert.Equal(t, "MOP", apply("currency", "澳門元 80"))
+	assert.Equal(t, "EUR", apply("currency:EUR", "12"))
+	assert.Nil(t, apply("currency", "12"))
+
+	assert.Equal(t, []interface{}{"a", "b"}, apply("split", "a, b,"))
+	assert.Equal(t, "a/b", apply([]interface{}{"split:;", "join:/"}, "a;b"))
+	assert.Equal(t, []interface{}{1.0, 2.5}, apply([]interface{}{"split", "number"}, "1,2.5"))
+	assert.Equal(t, []interface{}{"debit", "其他"}, apply(map[string]interface{}{
+		"map": map[string]interface{}{"支出": "debit", "收入": "credit"}}, []string{"支出", "其他"}))
+
+	assert.Equal(t, "http://a.com/list/2.html", apply("url", "2.html"))
+	assert.Equal(t, "http://a.com/item?id=3", apply("url", "/item?id=3"))
+	assert.Equal(t, "http://b.com/x", apply("url:http://b.com/", "x"))
+	p, _ := newPipe("url")
+	assert.Equal(t, "x", p.apply("x", nil))
+
+	for _, spec := range []interface{}{"upper", "number:xx", map[string]interface{}{"map": "a"}, 1.0} {
+		_, err := newPipe(spec)
+		assert.Error(t, err)
+	}
+}
+
+func TestPipeExtractor(t *testing.T) {
+	html := `
+        <html><body><table>
+            <tr><td>2016年1月2日</td><td> 8,000.00元 </td><td><a href="/d/1">工资</a></td></tr>
+            <tr><td>2016年1月5日</td><td>-120.50元</td><td><a href="/d/2">消费</a></td></tr>
+        </table></body></html>
+    `
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "_root": "tr",
+            "_array": true,
+            "date": {"_query": "td:nth-child(1)", "_pipe": "date"},
+            "amount": {"_query": "td:nth-child(2)", "_pipe": ["number"]},
+            "currency": {"_query": "td:nth-child(2)", "_pipe": "currency"},
+            "link": {"_query": "a&attr=href", "_pipe": "url:http://a.com/list"}
+        }
+    `), &config)
+	ret, err := Extract([]byte(html), config, "html", nil)
+	assert.NoError(t, err)
+	b, _ := json.Marshal(ret)
+	assert.Equal(t, `[{"amount":8000,"currency":"CNY","date":"2016-01-02","link":"http://a.com/d/1"},`+
+		`{"amount":-120.5,"currency":"CNY","date":"2016-01-05","link":"http://a.com/d/2"}]`, string(b))
+
+	ret, err = Extract([]byte(`{"data": {"balance": "1,000.50", "opened": "2016-01-02 08:05:00"}}`), map[string]interface{}{
+		"balance": map[string]interface{}{"_query": "data.balance", "_pipe": "number"},
+		"opened":  map[string]interface{}{"_query": "data.opened", "_pipe": "date"},
+	}, "json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"balance": 1000.5, "opened": "2016-01-02T08:05:00"}, ret)
+
+	ret, err = Extract([]byte(statement), map[string]interface{}{
+		"_header": 2.0, "_footer": 1.0, "_array": true,
+		"amount": map[string]interface{}{"_query": "金额", "_pipe": "number"},
+	}, "csv", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"amount": 8000.0},
+		map[string]interface{}{"amount": -120.5},
+	}, ret)
+
+	assert.NoError(t, ValidatePipes(config))
+	assert.EqualError(t, ValidatePipes(map[string]interface{}{
+		"a": map[string]interface{}{"b": map[string]interface{}{"_pipe": "trim"}},
+	}), "a.b: _pipe without _query")
+}
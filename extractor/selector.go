@@ -70,6 +70,12 @@ func (p *HtmlSelector) PostProcess(s *goquery.Selection) string {
 			return ""
 		}
 	}
+	return p.process(ret)
+}
+
+// process applies regex, replace, default, prefix, suffix and base_url to
+// the text of a query.
+func (p *HtmlSelector) process(ret string) string {
 	ret = strings.TrimSpace(ret)
 	if len(p.Regex) > 0 {
 		ret = regexExtract(ret, p.Regex)
@@ -116,6 +122,16 @@ func (p *HtmlSelector) Query(doc *goquery.Selection) interface{} {
 	var s *goquery.Selection
 	if p.Xpath == ":this" {
 		s = doc
+	} else if expr, ok := isXPath(p.Xpath); ok {
+		nodes, val, err := xpathEval(doc, expr)
+		if err != nil {
+			dlog.Warn("xpath %s error: %v", expr, err)
+			return nil
+		}
+		if nodes == nil {
+			return p.process(formatValue(val))
+		}
+		s = &goquery.Selection{Nodes: nodes}
 	} else {
 		s = doc.Find(p.Xpath)
 	}
@@ -0,0 +1,297 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/jsonpath"
+	"github.com/xuri/excelize/v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A table is extracted from csv, tsv and xlsx documents. The keys of a map
+// config are queries of columns: a header name, #n for the nth column, or * for
+// all the columns by header. With _array the map is extracted from each row,
+// otherwise each query gives the whole column. _header is the row of the
+// header starting from 1, 0 when there is none, and defaults to 1. The rows
+// above it and the last _footer rows are skipped, as are the empty rows.
+// _sheet selects the sheet of xlsx by name or by number, the first one by
+// default.
+const (
+	DEFAULT_HEADER_ROW = 1
+	COLUMN_PREFIX      = "#"
+	ALL_COLUMNS        = "*"
+)
+
+// tableSource returns the records of sheet, which is empty for the default one.
+type tableSource func(sheet string) ([][]string, error)
+
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func getInt(m map[string]interface{}, key string, def int) int {
+	switch v := m[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func isEmptyRow(row []string) bool {
+	for _, cell := range row {
+		if len(strings.TrimSpace(cell)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func newTable(records [][]string, headerRow, footer int) *table {
+	ret := &table{}
+	if headerRow > 0 {
+		if headerRow > len(records) {
+			return ret
+		}
+		for _, h := range records[headerRow-1] {
+			ret.header = append(ret.header, strings.TrimSpace(h))
+		}
+		records = records[headerRow:]
+	}
+	if footer > 0 {
+		if footer >= len(records) {
+			return ret
+		}
+		records = records[:len(records)-footer]
+	}
+	for _, row := range records {
+		if !isEmptyRow(row) {
+			ret.rows = append(ret.rows, row)
+		}
+	}
+	return ret
+}
+
+// column returns the index of the column of query, or -1.
+func (t *table) column(query string) int {
+	if strings.HasPrefix(query, COLUMN_PREFIX) {
+		if n, err := strconv.Atoi(query[len(COLUMN_PREFIX):]); err == nil && n > 0 {
+			return n - 1
+		}
+	}
+	for i, h := range t.header {
+		if h == query {
+			return i
+		}
+	}
+	return -1
+}
+
+func cell(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (t *table) rowMap(row []string) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for i := range row {
+		name := COLUMN_PREFIX + strconv.Itoa(i+1)
+		if i < len(t.header) && len(t.header[i]) > 0 {
+			name = t.header[i]
+		}
+		ret[name] = cell(row, i)
+	}
+	return ret
+}
+
+// cellQuery returns the cell of query in row.
+func (t *table) cellQuery(row []string, qp string, c Context) interface{} {
+	tks := strings.SplitN(qp, "||", 2)
+	qv := parse(tks[0], c)
+	var val interface{}
+	if strings.HasPrefix(qv, "c:") {
+		val = qv[2:]
+	} else if qv == ALL_COLUMNS {
+		val = t.rowMap(row)
+	} else if i := t.column(qv); i >= 0 {
+		val = cell(row, i)
+	}
+
+	if len(tks) == 2 && c != nil {
+		c.Set("_v", val)
+		return c.Parse(tks[1])
+	}
+	return val
+}
+
+func (t *table) rowExtract(row []string, config interface{}, c Context) interface{} {
+	if v, ok := config.(string); ok {
+		return t.cellQuery(row, v, c)
+	}
+	if m, ok := config.(map[string]interface{}); ok {
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(t.rowExtract(row, q, c), c)
+		}
+		ret := make(map[string]interface{})
+		for k, v := range m {
+			key := k
+			if c != nil && strings.Contains(key, "{{") {
+				key = c.Parse(key)
+			}
+			ret[key] = t.rowExtract(row, v, c)
+		}
+		return ret
+	}
+	return nil
+}
+
+// columnQuery returns the cells of query in all the rows.
+func (t *table) columnQuery(qp string, c Context) interface{} {
+	qv := parse(strings.SplitN(qp, "||", 2)[0], c)
+	if !strings.HasPrefix(qv, "c:") && qv != ALL_COLUMNS && t.column(qv) < 0 {
+		return nil
+	}
+	ret := []interface{}{}
+	for _, row := range t.rows {
+		ret = append(ret, t.cellQuery(row, qp, c))
+	}
+	return ret
+}
+
+// columnExtract extracts config from the columns of t, where maps other than
+// pipes are tables of their own.
+func (t *table) columnExtract(src tableSource, sheet string, config interface{}, c Context) interface{} {
+	if s, ok := config.(string); ok {
+		return t.columnQuery(s, c)
+	}
+	if m, ok := config.(map[string]interface{}); ok {
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(t.columnExtract(src, sheet, q, c), c)
+		}
+	}
+	return tableExtract(src, sheet, config, c)
+}
+
+func tableExtract(src tableSource, sheet string, config interface{}, c Context) interface{} {
+	m, ok := config.(map[string]interface{})
+	if !ok {
+		records, err := src(sheet)
+		if err != nil {
+			dlog.Warn("table extract error: %v", err)
+			return nil
+		}
+		if v, ok := config.(string); ok {
+			return newTable(records, DEFAULT_HEADER_ROW, 0).columnQuery(v, c)
+		}
+		return nil
+	}
+	if q, p, ok := pipeQuery(m); ok {
+		return p.apply(tableExtract(src, sheet, q, c), c)
+	}
+
+	if s := jsonpath.GetString(m, "_sheet"); len(s) > 0 {
+		sheet = parse(s, c)
+	} else if n := getInt(m, "_sheet", 0); n > 0 {
+		sheet = strconv.Itoa(n)
+	}
+	records, err := src(sheet)
+	if err != nil {
+		dlog.Warn("table extract error: %v", err)
+		return nil
+	}
+	t := newTable(records, getInt(m, "_header", DEFAULT_HEADER_ROW), getInt(m, "_footer", 0))
+	isArray := jsonpath.GetBool(m, "_array")
+	for _, k := range []string{"_sheet", "_header", "_footer", "_array"} {
+		delete(m, k)
+	}
+	if isArray {
+		ret := []interface{}{}
+		for _, row := range t.rows {
+			ret = append(ret, t.rowExtract(row, config, c))
+		}
+		return ret
+	}
+	ret := make(map[string]interface{})
+	for k, v := range m {
+		key := k
+		if c != nil && strings.Contains(key, "{{") {
+			key = c.Parse(key)
+		}
+		ret[key] = t.columnExtract(src, sheet, v, c)
+	}
+	return ret
+}
+
+func csvSource(body []byte, comma rune) tableSource {
+	return func(string) ([][]string, error) {
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte{0xef, 0xbb, 0xbf})))
+		r.Comma = comma
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+		return r.ReadAll()
+	}
+}
+
+// xlsxExtract extracts a table from body, and closes the workbook, with the
+// temporary files excelize may keep for it, when done.
+func xlsxExtract(body []byte, config interface{}, c Context) (interface{}, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return tableExtract(xlsxSource(f), "", config, c), nil
+}
+
+func xlsxSource(f *excelize.File) tableSource {
+	return func(sheet string) ([][]string, error) {
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("no sheet in xlsx")
+		}
+		if len(sheet) == 0 {
+			return f.GetRows(sheets[0])
+		}
+		for _, name := range sheets {
+			if name == sheet {
+				return f.GetRows(sheet)
+			}
+		}
+		if n, err := strconv.Atoi(sheet); err == nil && n >= 1 && n <= len(sheets) {
+			return f.GetRows(sheets[n-1])
+		}
+		return nil, fmt.Errorf("no sheet %s in xlsx", sheet)
+	}
+}
+
+// pdfText returns the plain text of all the pages of a pdf, the pdf package
+// panics on some malformed documents.
+func pdfText(body []byte) (ret string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bad pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", err
+	}
+	text, err := r.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(text)
+	return string(b), err
+}
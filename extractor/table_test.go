@@ -0,0 +1,131 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+	"strings"
+	"testing"
+)
+
+const statement = "\xef\xbb\xbf账户,6222 0000\n" +
+	"交易日期,摘要,金额,余额\n" +
+	"2016-01-02,工资,\"8,000.00\",9000.00\n" +
+	"\n" +
+	"2016-01-05,消费,-120.50,8879.50\n" +
+	"合计,,7879.50,\n"
+
+func TestCsvExtractor(t *testing.T) {
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "account": {"_header": 0, "no": "#2"},
+            "transactions": {
+                "_header": 2,
+                "_footer": 1,
+                "_array": true,
+                "date": "交易日期",
+                "amount": "金额",
+                "balance": "#4",
+                "missing": "对方户名",
+                "kind": "c:bank"
+            },
+            "dates": {"_header": 2, "_footer": 1, "date": "交易日期"},
+            "rows": {"_header": 2, "_footer": 1, "_array": true, "row": "*"}
+        }
+    `), &config)
+	ret, err := Extract([]byte(statement), config, "csv", nil)
+	assert.NoError(t, err)
+	m := ret.(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"no": []interface{}{"6222 0000", "摘要", "工资", "消费", ""}}, m["account"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"date": "2016-01-02", "amount": "8,000.00", "balance": "9000.00", "missing": nil, "kind": "bank"},
+		map[string]interface{}{"date": "2016-01-05", "amount": "-120.50", "balance": "8879.50", "missing": nil, "kind": "bank"},
+	}, m["transactions"])
+	assert.Equal(t, map[string]interface{}{"date": []interface{}{"2016-01-02", "2016-01-05"}}, m["dates"])
+	rows := m["rows"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"row": map[string]interface{}{
+		"交易日期": "2016-01-05", "摘要": "消费", "金额": "-120.50", "余额": "8879.50"}}, rows[1])
+
+	tsv := strings.Replace(strings.Replace(statement, ",", "\t", -1), "\"8\t000.00\"", "8000.00", 1)
+	ret, err = Extract([]byte(tsv), map[string]interface{}{"_header": 2.0, "_array": true, "amount": "金额"}, "tsv", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "8000.00", ret.([]interface{})[0].(map[string]interface{})["amount"])
+}
+
+func TestXlsxExtractor(t *testing.T) {
+	f := excelize.NewFile()
+	f.NewSheet("明细")
+	f.SetSheetRow("Sheet1", "A1", &[]interface{}{"户名", "张三"})
+	f.SetSheetRow("明细", "A1", &[]interface{}{"日期", "金额"})
+	f.SetSheetRow("明细", "A2", &[]interface{}{"2016-01-02", 8000})
+	f.SetSheetRow("明细", "A3", &[]interface{}{"2016-01-05", -120.5})
+	buf, err := f.WriteToBuffer()
+	assert.NoError(t, err)
+
+	var config interface{}
+	json.Unmarshal([]byte(`
+        {
+            "name": {"_header": 0, "value": "#2"},
+            "details": {"_sheet": "明细", "_array": true, "date": "日期", "amount": "金额"},
+            "amounts": {"_sheet": 2, "amount": "金额"},
+            "none": {"_sheet": "汇总", "amount": "金额"}
+        }
+    `), &config)
+	ret, err := Extract(buf.Bytes(), config, "xlsx", nil)
+	assert.NoError(t, err)
+	m := ret.(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"value": []interface{}{"张三"}}, m["name"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"date": "2016-01-02", "amount": "8000"},
+		map[string]interface{}{"date": "2016-01-05", "amount": "-120.5"},
+	}, m["details"])
+	assert.Equal(t, map[string]interface{}{"amount": []interface{}{"8000", "-120.5"}}, m["amounts"])
+	assert.Nil(t, m["none"])
+
+	_, err = Extract([]byte("not a zip"), config, "xlsx", nil)
+	assert.Error(t, err)
+}
+
+// simplePdf returns a pdf of one page showing lines in Helvetica.
+func simplePdf(lines ...string) []byte {
+	content := "BT /F1 12 Tf 72 720 Td 14 TL\n"
+	for _, line := range lines {
+		content += "(" + line + ") Tj T*\n"
+	}
+	content += "ET"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	out := "%PDF-1.4\n"
+	offsets := []int{}
+	for i, obj := range objects {
+		offsets = append(offsets, len(out))
+		out += fmt.Sprintf("%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := len(out)
+	out += fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		out += fmt.Sprintf("%010d 00000 n \n", off)
+	}
+	out += fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(out)
+}
+
+func TestPdfExtractor(t *testing.T) {
+	body := simplePdf("Statement of account 6222-0000", "Closing balance: 8879.50")
+	ret, err := Extract(body, map[string]interface{}{
+		"account": "account ([0-9-]+)",
+		"balance": "balance: ([0-9.]+)",
+	}, "pdf", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"account": "6222-0000", "balance": "8879.50"}, ret)
+
+	_, err = Extract([]byte("not a pdf"), "(.*)", "pdf", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,258 @@
+package extractor
+
+import (
+	"bytes"
+	"errors"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/jsonpath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+)
+
+var xmlEncodingReg = regexp.MustCompile(`^(\s*<\?xml[^>]*encoding=["'])[^"']*(["'])`)
+
+// parseXml parses body, whose encoding declaration is ignored once the
+// downloader transcoded it to utf-8.
+func parseXml(body []byte) (*xmlquery.Node, error) {
+	if utf8.Valid(body) {
+		body = xmlEncodingReg.ReplaceAll(body, []byte("${1}utf-8${2}"))
+	}
+	return xmlquery.Parse(bytes.NewReader(body))
+}
+
+// soapNamespaces are bound to the soap and soap12 prefixes unless the document
+// or _ns binds them to other uris.
+var soapNamespaces = map[string]string{
+	"soap":   "http://schemas.xmlsoap.org/soap/envelope/",
+	"soap12": "http://www.w3.org/2003/05/soap-envelope",
+}
+
+var soapStartReg = regexp.MustCompile(`^(<\?xml|<([\w.-]+:)?Envelope[\s/>])`)
+
+// isSoap tells whether body is a SOAP 1.1 or 1.2 envelope. It has to start
+// like one, since html pages may well mention the SOAP uris.
+func isSoap(body string) bool {
+	body = strings.TrimSpace(body)
+	if !soapStartReg.MatchString(body) || !strings.Contains(body, "Envelope") {
+		return false
+	}
+	for _, uri := range soapNamespaces {
+		if strings.Contains(body, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// withNamespaces returns ns with the prefixes of _ns in config added. Prefixed
+// names match the uri bound to their prefix whatever the prefix of the
+// document is, names without prefix match unprefixed elements, like those of
+// a default namespace. Use local-name() to ignore namespaces.
+func withNamespaces(ns map[string]string, config interface{}) (map[string]string, error) {
+	if config == nil {
+		return ns, nil
+	}
+	m, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("_ns should map prefixes to namespace uris")
+	}
+	ret := make(map[string]string)
+	for k, v := range ns {
+		ret[k] = v
+	}
+	for k, v := range m {
+		uri, ok := v.(string)
+		if !ok {
+			return nil, errors.New("namespace uri of " + k + " is not a string")
+		}
+		ret[k] = uri
+	}
+	return ret, nil
+}
+
+// docNamespaces returns the soap namespaces with the prefixes declared in doc
+// added, so that the prefixes of the document work without _ns.
+func docNamespaces(doc *xmlquery.Node) map[string]string {
+	ret := make(map[string]string)
+	for k, v := range soapNamespaces {
+		ret[k] = v
+	}
+	var walk func(n *xmlquery.Node)
+	walk = func(n *xmlquery.Node) {
+		for _, attr := range n.Attr {
+			if attr.Name.Space == "xmlns" {
+				ret[attr.Name.Local] = attr.Value
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return ret
+}
+
+func compileXPath(expr string, ns map[string]string) (*xpath.Expr, error) {
+	if len(ns) == 0 {
+		return xpath.Compile(expr)
+	}
+	return xpath.CompileWithNS(expr, ns)
+}
+
+// xmlEval evaluates expr at nodes like xpathEval, with the namespaces ns.
+func xmlEval(nodes []*xmlquery.Node, expr string, ns map[string]string) ([]*xmlquery.Node, interface{}, error) {
+	e, err := compileXPath(expr, ns)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := []*xmlquery.Node{}
+	seen := make(map[*xmlquery.Node]bool)
+	for _, n := range nodes {
+		v := e.Evaluate(xmlquery.CreateXPathNavigator(n))
+		if _, ok := v.(*xpath.NodeIterator); !ok {
+			return nil, v, nil
+		}
+		for _, m := range xmlquery.QuerySelectorAll(n, e) {
+			if !seen[m] {
+				seen[m] = true
+				ret = append(ret, m)
+			}
+		}
+	}
+	return ret, nil, nil
+}
+
+func (p *HtmlSelector) xmlText(n *xmlquery.Node) string {
+	if len(p.Attr) == 0 || p.Attr == "text" {
+		return n.InnerText()
+	}
+	if p.Attr == "xml" || p.Attr == "html" {
+		return n.OutputXML(false)
+	}
+	return n.SelectAttr(p.Attr)
+}
+
+// QueryXml is Query for the xml doc type, where the selector is an XPath
+// expression with or without XPATH_PREFIX using the namespaces ns.
+func (p *HtmlSelector) QueryXml(nodes []*xmlquery.Node, ns map[string]string) interface{} {
+	defer func() {
+		if err := recover(); err != nil {
+			dlog.Warn("run error:%v", err)
+			dlog.Warn("selector: %s", p.Xpath)
+			debug.PrintStack()
+		}
+	}()
+
+	s := nodes
+	if p.Xpath != ":this" {
+		expr, _ := isXPath(p.Xpath)
+		var val interface{}
+		var err error
+		s, val, err = xmlEval(nodes, expr, ns)
+		if err != nil {
+			dlog.Warn("xpath %s error: %v", expr, err)
+			return nil
+		}
+		if s == nil {
+			return p.process(formatValue(val))
+		}
+	}
+
+	if len(s) == 1 && p.Array != "true" {
+		return p.process(p.xmlText(s[0]))
+	}
+	if len(s) > 1 && p.Array == "false" {
+		texts := make([]string, 0, len(s))
+		for _, n := range s {
+			texts = append(texts, p.xmlText(n))
+		}
+		return p.process(strings.Join(texts, ""))
+	}
+	if len(s) > 0 {
+		ret := make([]string, 0, len(s))
+		for _, n := range s {
+			ret = append(ret, p.process(p.xmlText(n)))
+		}
+		return ret
+	}
+	return nil
+}
+
+func xmlQuery(nodes []*xmlquery.Node, qp string, ns map[string]string, c Context) interface{} {
+	tks := strings.SplitN(qp, "||", 2)
+	q := tks[0]
+	qv := parse(q, c)
+	var val interface{}
+	if strings.HasPrefix(qv, "c:") {
+		val = qv[2:]
+	} else {
+		hs := NewHtmlSelector(qv)
+		val = hs.QueryXml(nodes, ns)
+	}
+
+	if len(tks) == 2 && c != nil {
+		p := tks[1]
+		c.Set("_v", val)
+		return c.Parse(p)
+	}
+
+	return val
+}
+
+func xmlExtract(nodes []*xmlquery.Node, config interface{}, ns map[string]string, c Context) interface{} {
+	if v, ok := config.(string); ok {
+		return xmlQuery(nodes, v, ns, c)
+	}
+
+	if m, ok := config.(map[string]interface{}); ok {
+		ns, err := withNamespaces(ns, m["_ns"])
+		if err != nil {
+			dlog.Warn("xml extract error: %v", err)
+			return nil
+		}
+		delete(m, "_ns")
+		if q, p, ok := pipeQuery(m); ok {
+			return p.apply(xmlExtract(nodes, q, ns, c), c)
+		}
+		root := jsonpath.GetString(m, "_root")
+		rs := nodes
+		if len(root) > 0 {
+			if c != nil && strings.Contains(root, "{{") {
+				root = c.Parse(root)
+			}
+			expr, _ := isXPath(root)
+			rs, _, err = xmlEval(nodes, expr, ns)
+			if err != nil {
+				dlog.Warn("xpath %s error: %v", expr, err)
+			}
+			if len(rs) == 0 {
+				return nil
+			}
+		}
+		isArray := jsonpath.GetBool(m, "_array")
+		delete(m, "_root")
+		delete(m, "_array")
+		if isArray {
+			ret := []interface{}{}
+			for _, n := range rs {
+				ret = append(ret, xmlExtract([]*xmlquery.Node{n}, config, ns, c))
+			}
+			return ret
+		} else {
+			ret := make(map[string]interface{})
+			for k, v := range m {
+				key := k
+				if c != nil && strings.Contains(key, "{{") {
+					key = c.Parse(key)
+				}
+				ret[key] = xmlExtract(rs, v, ns, c)
+			}
+			return ret
+		}
+	}
+	return nil
+}
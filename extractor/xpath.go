@@ -0,0 +1,62 @@
+package extractor
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/xlvector/dlog"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// XPATH_PREFIX makes a selector of the html doc type an XPath 1.0 expression
+// instead of a css selector, e.g. xpath://td[.='name']/following-sibling::td.
+// The expression is evaluated at each node of the current _root, which is also
+// where absolute paths start.
+const XPATH_PREFIX = "xpath:"
+
+func isXPath(query string) (string, bool) {
+	if strings.HasPrefix(query, XPATH_PREFIX) {
+		return strings.TrimSpace(query[len(XPATH_PREFIX):]), true
+	}
+	return query, false
+}
+
+// xpathEval evaluates expr at the nodes of s. It returns the matched nodes, an
+// attribute as an element holding its value, or the value of expr when it is
+// not a node set, like count(//tr) or string(@href).
+func xpathEval(s *goquery.Selection, expr string) ([]*html.Node, interface{}, error) {
+	e, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := []*html.Node{}
+	seen := make(map[*html.Node]bool)
+	for _, n := range s.Nodes {
+		v := e.Evaluate(htmlquery.CreateXPathNavigator(n))
+		if _, ok := v.(*xpath.NodeIterator); !ok {
+			return nil, v, nil
+		}
+		for _, m := range htmlquery.QuerySelectorAll(n, e) {
+			if !seen[m] {
+				seen[m] = true
+				ret = append(ret, m)
+			}
+		}
+	}
+	return ret, nil, nil
+}
+
+// find returns the selection of query in s, which is either a css selector
+// or an XPath expression selecting nodes.
+func find(s *goquery.Selection, query string) *goquery.Selection {
+	expr, ok := isXPath(query)
+	if !ok {
+		return s.Find(query)
+	}
+	nodes, _, err := xpathEval(s, expr)
+	if err != nil {
+		dlog.Warn("xpath %s error: %v", expr, err)
+	}
+	return &goquery.Selection{Nodes: nodes}
+}
@@ -1,24 +1,29 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/xlvector/dlog"
 	"github.com/xlvector/higgs/cmd"
 	"github.com/xlvector/higgs/config"
+	"github.com/xlvector/higgs/cookie"
 	hproxy "github.com/xlvector/higgs/proxy"
 	"github.com/xlvector/higgs/task"
+	"github.com/xlvector/higgs/util"
 	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"runtime"
 	"runtime/debug"
+	"time"
 )
 
 const (
 	IpMangerKey = "IP_MANAGER_KEY"
+	tmplDir     = "./etc/tmpls/"
 )
 
 var health bool
@@ -26,7 +31,13 @@ var taskManager *task.TaskManager
 
 func init() {
 	health = true
-	taskManager = task.NewTaskManager("./etc/tmpls/")
+}
+
+// setup loads the config and then the templates, since the templates are
+// validated against the client and header profiles of the config.
+func setup(conf, dir string) {
+	config.Init(conf)
+	taskManager = task.NewTaskManager(dir)
 }
 
 func HandleHealth(w http.ResponseWriter, req *http.Request) {
@@ -64,6 +75,58 @@ func GetConfig(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "%s", result)
 }
 
+func GetTmplErrors(w http.ResponseWriter, req *http.Request) {
+	result, _ := json.Marshal(taskManager.Errors())
+	w.Header().Set("Content-Type", "application/json; encoding=UTF-8")
+	fmt.Fprintf(w, "%s", result)
+}
+
+// adminOnly rejects the requests without the AdminToken of the config in the
+// X-Admin-Token header, and all of them when there is no AdminToken.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := config.Get().AdminToken
+		if len(token) == 0 || subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
+
+func reload() map[string]interface{} {
+	ret := map[string]interface{}{}
+	if err := config.Reload(); err != nil {
+		dlog.Warn("fail to reload config: %v", err)
+		ret["config_error"] = err.Error()
+	}
+	if err := taskManager.Reload(); err != nil {
+		dlog.Warn("fail to reload templates: %v", err)
+		ret["tmpl_error"] = err.Error()
+	}
+	ret["tmpl_errors"] = taskManager.Errors()
+	return ret
+}
+
+func HandleReload(w http.ResponseWriter, req *http.Request) {
+	result, _ := json.Marshal(reload())
+	w.Header().Set("Content-Type", "application/json; encoding=UTF-8")
+	fmt.Fprintf(w, "%s", result)
+}
+
+func watchReload(interval time.Duration) {
+	last := util.LastModTime(config.File(), tmplDir)
+	tc := time.NewTicker(interval)
+	for _ = range tc.C {
+		mt := util.LastModTime(config.File(), tmplDir)
+		if mt.After(last) {
+			last = mt
+			dlog.Info("config or templates changed, reload")
+			reload()
+		}
+	}
+}
+
 type CookieEntry struct {
 	Name       string
 	Value      string
@@ -124,24 +187,74 @@ func addToSite(ret map[string]map[string]CookieEntry, site string, entry CookieE
 	ret[site] = siteMap
 }
 
+// ConvertCookie converts the cookie param from the from format, detected when
+// empty, to the to format, json by default.
+func ConvertCookie(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	to := req.FormValue("to")
+	if len(to) == 0 {
+		to = cookie.FORMAT_JSON
+	}
+	result, err := cookie.Convert(req.FormValue("from"), to, []byte(req.FormValue("cookie")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "%s", result)
+}
+
+func newSessionStore() cmd.SessionStore {
+	conf := config.Get()
+	timeout := time.Duration(conf.Session.Timeout) * time.Second
+	switch conf.Session.Store {
+	case "file":
+		store, err := cmd.NewFileSessionStore(conf.Session.Path, timeout)
+		if err != nil {
+			dlog.Warn("fail to create file session store: %v", err)
+			return nil
+		}
+		return store
+	case "redis":
+		return cmd.NewRedisSessionStore(conf.Redis.Host, timeout)
+	}
+	return nil
+}
+
 func main() {
 	runtime.GOMAXPROCS(4)
 	debug.SetGCPercent(70)
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	port := flag.String("port", "8001", "port number")
 	env := flag.String("env", "prod", "env")
+	watch := flag.Duration("watch", 0, "interval to check config and templates for changes, 0 to disable")
+	msgTimeout := flag.Duration("msg_timeout", cmd.DEFAULT_MESSAGE_TIMEOUT, "max time /submit waits for the next message")
 	flag.Parse()
-	config.Init("./etc/config_" + *env + ".json")
+	setup("./etc/config_"+*env+".json", tmplDir)
+	if *watch > 0 {
+		go watchReload(*watch)
+	}
 	pm := hproxy.NewProxyManager("./etc/proxy.json")
-	service := cmd.NewCasperServer(task.NewTaskCmdFactory(taskManager, pm))
+	factory := task.NewTaskCmdFactory(taskManager, pm)
+	service := cmd.NewCasperServer(factory)
+	service.SetMessageTimeout(*msgTimeout)
+	if store := newSessionStore(); store != nil {
+		factory.SetSessionStore(store)
+		service.SetSessionStore(store)
+	}
 
 	http.Handle("/submit", service)
+	http.HandleFunc("/status", service.ServeStatus)
+	http.HandleFunc("/stream", service.ServeStream)
 	http.HandleFunc("/start", HandleStart)
 	http.HandleFunc("/shutdown", HandleShutdown)
 	http.HandleFunc("/health", HandleHealth)
 	http.HandleFunc("/get/config", GetConfig)
+	http.HandleFunc("/get/tmpl_errors", adminOnly(GetTmplErrors))
+	http.HandleFunc("/reload", adminOnly(HandleReload))
 	http.Handle("/proxy", pm)
 	http.HandleFunc("/format_cookie", FormatCookie)
+	http.HandleFunc("/convert_cookie", ConvertCookie)
+	http.HandleFunc("/cookies", adminOnly(service.ServeCookies))
 	http.Handle("/site/",
 		http.StripPrefix("/site/",
 			http.FileServer(http.Dir("./site"))))
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "higgs")
+	defer os.RemoveAll(dir)
+	os.Mkdir(dir+"/tmpls", 0700)
+	ioutil.WriteFile(dir+"/config.json", []byte(`{
+        "Templates": {"mock": "mock.json"},
+        "ClientProfiles": {"modern": {"MinVersion": "1.2"}},
+        "HeaderProfiles": {"chrome": {"UserAgents": ["Mozilla/5.0 Chrome"]}}
+    }`), 0600)
+	ioutil.WriteFile(dir+"/tmpls/mock.json", []byte(`{
+        "client_profile": "modern",
+        "header_profile": "chrome",
+        "steps": [{"page": "http://a.com"}]
+    }`), 0600)
+
+	setup(dir+"/config.json", dir+"/tmpls/")
+	assert.Equal(t, 0, len(taskManager.Errors()))
+	assert.NotNil(t, taskManager.Get("mock"))
+}
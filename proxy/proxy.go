@@ -78,7 +78,7 @@ func (p *Proxy) Available() bool {
 	}
 	conn, err := net.DialTimeout("tcp", p.IP, time.Second*5)
 	if err != nil {
-		//util.SlackMessage(config.Instance.SlackApi, "#crawler", "higgs", "proxy "+p.IP+" is not available")
+		//util.SlackMessage(config.Get().SlackApi, "#crawler", "higgs", "proxy "+p.IP+" is not available")
 		return false
 	}
 	conn.Close()
@@ -105,10 +105,10 @@ func NewProxyManager(conf string) *ProxyManager {
 		tmplProxies: make(map[string]map[string]*Proxy),
 		lock:        &sync.RWMutex{},
 	}
-	if config.Instance.HasRedis() {
+	if conf := config.Get(); conf.HasRedis() {
 		ret.client = redis.NewClient(&redis.Options{
-			Addr:        config.Instance.Redis.Host,
-			DialTimeout: time.Duration(config.Instance.Redis.Timeout) * time.Second,
+			Addr:        conf.Redis.Host,
+			DialTimeout: time.Duration(conf.Redis.Timeout) * time.Second,
 		})
 	}
 	if len(conf) > 0 {
@@ -0,0 +1,112 @@
+package task
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CALLBACK_SIGNATURE_HEADER = "X-Higgs-Signature"
+	CALLBACK_MAX_RETRY        = 5
+)
+
+var (
+	callbackBackoff = time.Second
+	callbackClient  = &http.Client{Timeout: 30 * time.Second}
+)
+
+type CallbackBody struct {
+	Id               string                 `json:"id"`
+	Tmpl             string                 `json:"tmpl"`
+	Output           *cmd.Output            `json:"output"`
+	ExtractorResults map[string]interface{} `json:"extractor_results"`
+}
+
+// Sign returns the hex HMAC-SHA256 of body with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PostCallback posts body to cb.Url until it gets a 2xx response, doubling
+// the wait after each failure.
+func PostCallback(cb *config.Callback, body []byte) error {
+	maxRetry := cb.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = CALLBACK_MAX_RETRY
+	}
+	wait := callbackBackoff
+	var err error
+	for i := 0; i < maxRetry; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		err = postCallbackOnce(cb, body)
+		if err == nil {
+			return nil
+		}
+		dlog.Warn("post callback to %s fail %d times: %v", cb.Url, i+1, err)
+	}
+	return err
+}
+
+func postCallbackOnce(cb *config.Callback, body []byte) error {
+	req, err := http.NewRequest("POST", cb.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(cb.Secret) > 0 {
+		req.Header.Set(CALLBACK_SIGNATURE_HEADER, "sha256="+Sign(cb.Secret, body))
+	}
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("callback status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// finish sends the final output of the command and posts it to the callback.
+// The command is marked finished first, a reader of the final output must
+// see it finished.
+func (p *TaskCmd) finish(msg *cmd.Output) {
+	atomic.StoreInt32(&p.finished, 1)
+	p.sendMessage(msg)
+	if p.callback == nil {
+		return
+	}
+	body, err := json.Marshal(&CallbackBody{
+		Id:               p.GetId(),
+		Tmpl:             p.tmpl,
+		Output:           msg,
+		ExtractorResults: p.downloader.ExtractorResults,
+	})
+	if err != nil {
+		dlog.Warn("%s marshal callback fail: %v", p.GetId(), err)
+		return
+	}
+	go func() {
+		if err := PostCallback(p.callback, body); err != nil {
+			dlog.Warn("%s give up callback: %v", p.GetId(), err)
+		}
+	}()
+}
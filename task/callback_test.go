@@ -0,0 +1,61 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostCallback(t *testing.T) {
+	callbackBackoff = 10 * time.Millisecond
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+Sign("secret", b), r.Header.Get(CALLBACK_SIGNATURE_HEADER))
+	}))
+	defer ts.Close()
+
+	body := []byte(`{"id":"1"}`)
+	err := PostCallback(&config.Callback{Url: ts.URL, Secret: "secret"}, body)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	n = 0
+	err = PostCallback(&config.Callback{Url: ts.URL, MaxRetry: 2}, body)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestGetCallback(t *testing.T) {
+	prev := config.Get()
+	defer config.Set(prev)
+	config.Set(&config.Config{Callbacks: map[string]*config.Callback{
+		"a": {Url: "http://a.com/cb", Secret: "secret", AllowedUrls: []string{"https://b.com/hook/"}},
+		"h": {Url: "http://a.com/cb", AllowedUrls: []string{"https://h/hook"}},
+	}})
+
+	assert.Equal(t, "http://a.com/cb", config.GetCallback("a", "").Url)
+	assert.Equal(t, "https://b.com/hook/1", config.GetCallback("a", "https://b.com/hook/1").Url)
+	for _, link := range []string{"http://b.com/hook/1", "https://b.com.evil.com/hook/1", "https://b.com/other", "http://evil.com"} {
+		cb := config.GetCallback("a", link)
+		assert.Equal(t, "http://a.com/cb", cb.Url)
+		assert.Equal(t, "secret", cb.Secret)
+	}
+	assert.Nil(t, config.GetCallback("b", "http://evil.com"))
+
+	for _, link := range []string{"https://h/hook", "https://h/hook/1"} {
+		assert.Equal(t, link, config.GetCallback("h", link).Url)
+	}
+	for _, link := range []string{"https://h/hook-evil", "https://h/hooks/other", "https://h/hook/../hooks"} {
+		assert.Equal(t, "http://a.com/cb", config.GetCallback("h", link).Url, link)
+	}
+}
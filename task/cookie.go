@@ -0,0 +1,75 @@
+package task
+
+import (
+	"errors"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cookie"
+	"io/ioutil"
+)
+
+const (
+	DEFAULT_COOKIES_KEY = "_cookies"
+)
+
+// CookieExport writes the cookies of the jar in Format, json by default, to
+// ContextKey and to Filename in the output folder when it is set. Use the
+// phantomjs format and cookie.txt to hand the session over to casperjs.
+type CookieExport struct {
+	Format     string `json:"format"`
+	ContextKey string `json:"context_key"`
+	Filename   string `json:"filename"`
+}
+
+func (p *CookieExport) format() string {
+	if len(p.Format) == 0 {
+		return cookie.FORMAT_JSON
+	}
+	return p.Format
+}
+
+func (p *CookieExport) key() string {
+	if len(p.ContextKey) == 0 {
+		return DEFAULT_COOKIES_KEY
+	}
+	return p.ContextKey
+}
+
+// importCookies adds cookiejar and the content of cookie_file, both in
+// cookie_format, to the jar.
+func (s *Step) importCookies(d *Downloader) error {
+	if len(s.CookieJar) > 0 {
+		err := d.ImportCookies(s.CookieFormat, []byte(d.Context.Parse(s.CookieJar)))
+		if err != nil {
+			return newStepError(ERROR_PARSE, err)
+		}
+	}
+	if len(s.CookieFile) > 0 {
+		if len(d.OutputFolder) == 0 {
+			return newStepError(ERROR_TEMPLATE, errors.New("no output folder to read "+s.CookieFile))
+		}
+		b, err := ioutil.ReadFile(d.OutputFolder + "/" + d.Context.Parse(s.CookieFile))
+		if err != nil {
+			return newStepError(ERROR_PARSE, err)
+		}
+		if err = d.ImportCookies(s.CookieFormat, b); err != nil {
+			return newStepError(ERROR_PARSE, err)
+		}
+	}
+	return nil
+}
+
+func (s *Step) exportCookies(d *Downloader) error {
+	b, err := d.ExportCookies(s.ExportCookies.format())
+	if err != nil {
+		return newStepError(ERROR_PARSE, err)
+	}
+	d.Context.Set(s.ExportCookies.key(), string(b))
+	if len(s.ExportCookies.Filename) > 0 && len(d.OutputFolder) > 0 {
+		fname := d.OutputFolder + "/" + d.Context.Parse(s.ExportCookies.Filename)
+		dlog.Info("write cookies to %s", fname)
+		if err = ioutil.WriteFile(fname, b, 0600); err != nil {
+			return newStepError(ERROR_UPLOAD, err)
+		}
+	}
+	return nil
+}
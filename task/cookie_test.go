@@ -0,0 +1,56 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/cookie"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCookieImportExport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(rw, &http.Cookie{Name: "sid", Value: "s1", Path: "/"})
+		}
+		if c, err := r.Cookie("sid"); err == nil {
+			rw.Write([]byte(c.Value))
+		}
+	}))
+	defer ts.Close()
+
+	folder, _ := ioutil.TempDir("", "cookie")
+	defer os.RemoveAll(folder)
+	d, _ := NewDownloader(nil, nil, folder, nil, nil)
+	step := &Step{
+		Page:          ts.URL + "/login",
+		ExportCookies: &CookieExport{Format: cookie.FORMAT_PHANTOMJS, Filename: "cookie.txt"},
+	}
+	assert.NoError(t, step.Do(d, nil, nil))
+	b, err := ioutil.ReadFile(folder + "/cookie.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, string(b), d.Context.Parse("{{._cookies}}"))
+	assert.True(t, strings.Contains(string(b), "sid=s1"))
+
+	netscape, err := d.ExportCookies(cookie.FORMAT_NETSCAPE)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(netscape), "\tsid\ts1"))
+
+	// a new session goes on from the file written for casperjs
+	d2, _ := NewDownloader(nil, nil, folder, nil, nil)
+	step = &Step{Page: ts.URL + "/home", CookieFile: "cookie.txt"}
+	assert.NoError(t, step.Do(d2, nil, nil))
+	assert.Equal(t, "s1", string(d2.LastPage))
+
+	d3, _ := NewDownloader(nil, nil, "", nil, nil)
+	step = &Step{Page: ts.URL + "/home", CookieJar: "{{.cookies}}"}
+	d3.Context.Set("cookies", string(netscape))
+	assert.NoError(t, step.Do(d3, nil, nil))
+	assert.Equal(t, "s1", string(d3.LastPage))
+
+	step = &Step{CookieJar: "garbage\tline", CookieFormat: cookie.FORMAT_NETSCAPE}
+	assert.Equal(t, ERROR_PARSE, ErrorClass(step.Do(d3, nil, nil)))
+}
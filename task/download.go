@@ -2,26 +2,26 @@ package task
 
 import (
 	"bytes"
-	"compress/gzip"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"github.com/axgle/mahonia"
 	"github.com/xlvector/dlog"
 	"github.com/xlvector/higgs/casperjs"
+	"github.com/xlvector/higgs/config"
 	"github.com/xlvector/higgs/context"
+	"github.com/xlvector/higgs/cookie"
 	hproxy "github.com/xlvector/higgs/proxy"
+	"github.com/xlvector/higgs/util"
 	"github.com/xlvector/persistent-cookiejar"
 	"golang.org/x/net/proxy"
 	"golang.org/x/net/publicsuffix"
 	"gopkg.in/redis.v2"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -34,6 +34,11 @@ type DownloaderConfig struct {
 	RedisHost         string
 	RedisTimeout      time.Duration
 	DisableOutputFile bool
+	FixtureMode       string
+	FixtureFile       string
+	Profile           *config.ClientProfile
+	Headers           *config.HeaderProfile
+	UserAgent         string
 }
 
 type Downloader struct {
@@ -42,12 +47,25 @@ type Downloader struct {
 	LastPageUrl         string
 	LastPageStatus      int
 	LastPageContentType string
+	Charset             string
+	Profile             *config.ClientProfile
+	Headers             *config.HeaderProfile
+	UserAgent           string
+	Redirect            *Redirect
+	Redirects           []string
+	LastLocation        string
 	Client              *http.Client
 	Context             *context.Context
 	ExtractorResults    map[string]interface{}
 	OutputFolder        string
 	UploadFiles         []string
 	RedisClient         *redis.Client
+	Fixture             *Fixture
+	Retry               *HttpRetry
+	Limiter             *RateLimiter
+	RateWaiting         func(host string, wait time.Duration)
+	Stream              *FileDownload
+	LastFile            *DownloadedFile
 }
 
 func NewHttpClientWithPersistentCookieJar() (*http.Client, *cookiejar.Jar) {
@@ -70,29 +88,96 @@ func NewHttpClientWithPersistentCookieJar() (*http.Client, *cookiejar.Jar) {
 	}, jar
 }
 
-func NewDownloader(cjs *casperjs.CasperJS, p *hproxy.Proxy, outFolder string, config *DownloaderConfig, pm *hproxy.ProxyManager) *Downloader {
+func NewDownloader(cjs *casperjs.CasperJS, p *hproxy.Proxy, outFolder string, config *DownloaderConfig, pm *hproxy.ProxyManager) (*Downloader, error) {
 	ret := &Downloader{
 		Context:          context.NewContext(cjs, p, pm),
 		OutputFolder:     outFolder,
 		LastPage:         nil,
 		ExtractorResults: make(map[string]interface{}),
+		Limiter:          Limiter,
 	}
 	if len(outFolder) > 0 {
 		err := os.MkdirAll(outFolder, 0766)
 		if err != nil {
-			dlog.Error("fail to mkdir %s: %v", outFolder, err)
-			return nil
+			return nil, err
 		}
 	}
 	ret.Client, ret.Jar = NewHttpClientWithPersistentCookieJar()
-	if p != nil {
+	ret.Client.CheckRedirect = ret.checkRedirect
+	if config != nil && len(config.FixtureMode) > 0 {
+		fname := config.FixtureFile
+		if len(fname) == 0 && len(outFolder) > 0 {
+			fname = outFolder + "/" + FIXTURE_FILENAME
+		}
+		if len(fname) == 0 {
+			dlog.Warn("no fixture file without output folder")
+		} else if err := ret.SetFixture(config.FixtureMode, fname); err != nil {
+			return nil, err
+		}
+	}
+	if config != nil {
+		ret.Headers = config.Headers
+		ret.UserAgent = config.UserAgent
+	}
+	if config != nil && config.Profile != nil {
+		ret.Profile = config.Profile
+		ret.Client.Timeout = seconds(config.Profile.Timeout, DEFAULT_CLIENT_TIMEOUT)
+		ret.SetProxy(p)
+	} else if p != nil {
 		ret.SetProxy(p)
 	}
+	return ret, nil
+}
+
+func (p *Downloader) SetFixture(mode, fname string) error {
+	f, err := NewFixture(mode, fname)
+	if err != nil {
+		return err
+	}
+	f.Transport = p.Client.Transport
+	p.Fixture = f
+	p.Client.Transport = f
+	return nil
+}
+
+func (p *Downloader) tmpl() string {
+	v, _ := p.Context.Get("tmpl")
+	ret, _ := v.(string)
 	return ret
 }
 
 func (p *Downloader) SetCookie(b string) {
-	p.Jar.ReadFrom(strings.NewReader(b))
+	if err := p.ImportCookies("", []byte(b)); err != nil {
+		dlog.Warn("set cookie error: %v", err)
+	}
+}
+
+// ImportCookies adds the cookies of b in format to the jar, the format is
+// detected when empty.
+func (p *Downloader) ImportCookies(format string, b []byte) error {
+	if len(format) == 0 {
+		format = cookie.Detect(b)
+	}
+	if format != cookie.FORMAT_JAR {
+		var err error
+		if b, err = cookie.Convert(format, cookie.FORMAT_JAR, b); err != nil {
+			return err
+		}
+	}
+	return p.Jar.ReadFrom(bytes.NewReader(b))
+}
+
+// ExportCookies returns all the cookies of the jar in format.
+func (p *Downloader) ExportCookies(format string) ([]byte, error) {
+	body := &bytes.Buffer{}
+	err := p.Jar.WriteTo(body)
+	if err != nil {
+		return nil, err
+	}
+	if format == cookie.FORMAT_JAR {
+		return body.Bytes(), nil
+	}
+	return cookie.Convert(cookie.FORMAT_JAR, format, body.Bytes())
 }
 
 func (p *Downloader) SaveCookie(fname string) error {
@@ -101,7 +186,7 @@ func (p *Downloader) SaveCookie(fname string) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(fname, body.Bytes(), 0655)
+	return ioutil.WriteFile(fname, body.Bytes(), 0600)
 }
 
 func (p *Downloader) ExtractorResultString() string {
@@ -137,33 +222,14 @@ func (p *Downloader) AddExtractorResult(data interface{}) {
 }
 
 func (self *Downloader) SetProxy(p *hproxy.Proxy) {
-	transport := &http.Transport{
-		DisableKeepAlives:     true,
-		ResponseHeaderTimeout: time.Second * 30,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-			MaxVersion:         tls.VersionTLS12,
-			MinVersion:         tls.VersionTLS10,
-			CipherSuites: []uint16{
-				tls.TLS_RSA_WITH_RC4_128_SHA,
-				tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
-				tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			},
-		},
+	transport, err := NewTransport(self.Profile)
+	if err != nil {
+		dlog.Warn("fail to create transport: %v", err)
+		return
 	}
 
 	if p == nil {
-		self.Client.Transport = transport
+		self.setTransport(transport)
 		return
 	}
 
@@ -183,8 +249,10 @@ func (self *Downloader) SetProxy(p *hproxy.Proxy) {
 			dlog.Warn("SetSocks5 Error:%s", err.Error())
 			return
 		}
+		transport.DialContext = nil
 		transport.Dial = dialSocks5Proxy.Dial
 	} else if p.Type == "http" {
+		transport.DialContext = nil
 		transport.Dial = func(netw, addr string) (net.Conn, error) {
 			timeout := time.Second * 30
 			deadline := time.Now().Add(timeout)
@@ -201,87 +269,138 @@ func (self *Downloader) SetProxy(p *hproxy.Proxy) {
 		}
 	}
 
-	self.Client.Transport = transport
+	self.setTransport(transport)
 	dlog.Warn("use proxy: %s", p.String())
 }
 
+func (self *Downloader) setTransport(transport http.RoundTripper) {
+	if self.Fixture != nil {
+		self.Fixture.Transport = transport
+		return
+	}
+	self.Client.Transport = transport
+}
+
 func (s *Downloader) constructPage(resp *http.Response) error {
 	defer resp.Body.Close()
-	body := make([]byte, 0)
-	switch resp.Header.Get("Content-Encoding") {
-	case "gzip":
-		reader, _ := gzip.NewReader(resp.Body)
-		defer reader.Close()
-		for {
-			buf := make([]byte, 1024)
-			n, err := reader.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			}
-			if n == 0 {
-				break
-			}
-			body = append(body, buf[:n]...)
-		}
-	default:
-		for {
-			buf := make([]byte, 1024)
-			n, err := resp.Body.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			}
-			if n == 0 {
-				break
-			}
-			body = append(body, buf[:n]...)
-		}
+	reader, err := decodeBody(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
 	}
 	s.LastPageUrl = resp.Request.URL.String()
-	s.LastPage = body
-	var charset string
-	s.LastPageContentType, charset = decodeCharset(string(s.LastPage), resp.Header.Get("Content-Type"))
+	s.LastPageContentType, _ = decodeCharset(resp.Header.Get("Content-Type"))
+	s.LastPage = s.transcode(body, resp.Header.Get("Content-Type"))
+	return nil
+}
 
-	if !strings.Contains(s.LastPageContentType, "image") && (strings.HasPrefix(charset, "gb") || strings.HasPrefix(charset, "GB")) {
-		enc := mahonia.NewDecoder("gbk")
-		cbody := []byte(enc.ConvertString(string(body)))
-		s.LastPage = cbody
+// decodeCharset returns the media type and the charset of a Content-Type.
+func decodeCharset(contentTypeHeader string) (string, string) {
+	if len(strings.TrimSpace(contentTypeHeader)) == 0 {
+		return "", ""
 	}
-	return nil
+	mediaType, params, err := mime.ParseMediaType(contentTypeHeader)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentTypeHeader, ";")[0])), ""
+	}
+	return mediaType, strings.ToLower(params["charset"])
 }
 
-func decodeCharset(body, contentTypeHeader string) (string, string) {
-	tks := strings.Split(contentTypeHeader, ";")
-	var content_type, charset string
+var binaryTypes = []string{"image/", "audio/", "video/", "application/octet-stream", "application/pdf", "application/zip",
+	"application/vnd.ms-excel", "application/vnd.openxmlformats-officedocument."}
 
-	if len(tks) == 1 {
-		content_type = strings.ToLower(tks[0])
-	}
-	if len(tks) == 2 {
-		kv := strings.Split(tks[1], "=")
-		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "charset" {
-			return strings.ToLower(tks[0]), strings.ToLower(kv[1])
+// transcode converts a text body to utf-8 from the charset forced by the
+// step, or the detected one. Binary bodies are kept as they are, whatever
+// their Content-Type says.
+func (s *Downloader) transcode(body []byte, contentType string) []byte {
+	sniffed := http.DetectContentType(body)
+	for _, t := range binaryTypes {
+		if strings.HasPrefix(s.LastPageContentType, t) || strings.HasPrefix(sniffed, t) {
+			return body
 		}
 	}
+	charset := s.Charset
+	if len(charset) == 0 {
+		charset = util.DetectCharset(body, contentType)
+	}
+	if len(charset) == 0 {
+		return body
+	}
+	ret, err := util.ToUTF8(body, charset)
+	if err != nil {
+		dlog.Warn("fail to decode %s from %s: %v", s.LastPageUrl, charset, err)
+		return body
+	}
+	return ret
+}
+
+func (s *Downloader) Get(link string, header map[string]string) ([]byte, error) {
+	return s.Request("GET", link, nil, "", header)
+}
 
-	reg := regexp.MustCompile("meta[^<>]*[ ]{1}charset=\"([^\"]+)\"")
-	result := reg.FindAllStringSubmatch(string(body), 1)
-	if len(result) > 0 {
-		group := result[0]
-		if len(group) > 1 {
-			charset = group[1]
+func (s *Downloader) Post(link string, params map[string]string, header map[string]string) ([]byte, error) {
+	uparams := url.Values{}
+	for k, v := range params {
+		uparams.Set(s.Context.Parse(k), s.Context.Parse(v))
+	}
+	dlog.Info("post paramter:%v", uparams)
+	return s.Request("POST", link, strings.NewReader(uparams.Encode()), "application/x-www-form-urlencoded; charset=UTF-8", header)
+}
+
+func (s *Downloader) PostRaw(link string, data []byte, header map[string]string) ([]byte, error) {
+	return s.Request("POST", link, bytes.NewReader(data), "text/plain; charset=UTF-8", header)
+}
+
+// Request sends a request of any method, contentType is only set when body
+// is not nil. Headers of the step override the default ones. The request is
+// sent again according to Retry.
+func (s *Downloader) Request(method, link string, body io.Reader, contentType string, header map[string]string) ([]byte, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = ioutil.ReadAll(body)
+		if err != nil {
+			return nil, newStepError(ERROR_TEMPLATE, err)
 		}
 	}
-	return content_type, charset
+	for attempt := 1; ; attempt++ {
+		status, retryAfter, err := s.doRequest(method, link, data, body != nil, contentType, header)
+		wait, ok := s.Retry.wait(attempt, status, err, retryAfter)
+		if !ok {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		dlog.Warn("retry %s %s in %v after attempt %d, status %d: %v", method, link, wait, attempt, status, err)
+		if s.Retry.SwitchProxy {
+			s.switchProxy()
+		}
+		time.Sleep(wait)
+	}
+	s.UpdateCookieToContext(link)
+	return s.LastPage, nil
 }
 
-func (s *Downloader) Get(link string, header map[string]string) ([]byte, error) {
-	dlog.Println(link)
-	req, err := http.NewRequest("GET", link, nil)
+func (s *Downloader) doRequest(method, link string, data []byte, hasBody bool, contentType string, header map[string]string) (int, string, error) {
+	dlog.Println(method, link)
+	var body io.Reader
+	if hasBody {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, link, body)
 	if err != nil {
 		dlog.Warn("new req error: %v", err)
-		return nil, err
+		return 0, "", newStepError(ERROR_TEMPLATE, err)
 	}
-	req.Header.Set("User-Agent", DEFAULT_USERAGENT)
+	if hasBody && len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.setProfileHeaders(req)
 	req.Header.Set("Referer", s.LastPageUrl)
 	if header != nil {
 		for name, value := range header {
@@ -289,79 +408,87 @@ func (s *Downloader) Get(link string, header map[string]string) ([]byte, error)
 		}
 	}
 
+	if s.Limiter != nil {
+		host := req.URL.Hostname()
+		s.Limiter.Wait(s.tmpl(), host, func(wait time.Duration) {
+			if s.RateWaiting != nil {
+				s.RateWaiting(host, wait)
+			}
+		})
+	}
+	s.Redirects = nil
+	s.LastLocation = ""
+	s.clearResponse()
+	start := time.Now()
 	resp, err := s.Client.Do(req)
-
 	if err != nil {
 		dlog.Warn("do req error: %v", err)
-		return nil, err
+		return 0, "", newStepError(ERROR_NETWORK, err)
 	}
 	if resp == nil {
-		return nil, errors.New("nil resp")
+		return 0, "", newStepError(ERROR_NETWORK, errors.New("nil resp"))
 	}
 	s.LastPageStatus = resp.StatusCode
-	err = s.constructPage(resp)
+	s.recordRedirects(resp)
+	retryAfter := resp.Header.Get("Retry-After")
+	if s.Stream != nil {
+		err = s.savePage(resp)
+	} else {
+		err = s.constructPage(resp)
+	}
+	s.recordResponse(resp, time.Since(start))
 	if err != nil {
-		return nil, err
+		return resp.StatusCode, retryAfter, newStepError(readErrorClass(err), err)
 	}
-	s.UpdateCookieToContext(link)
-	return s.LastPage, nil
+	return resp.StatusCode, retryAfter, nil
 }
 
-func (s *Downloader) Post(link string, params map[string]string, header map[string]string) ([]byte, error) {
-	uparams := url.Values{}
-	for k, v := range params {
-		uparams.Set(s.Context.Parse(k), s.Context.Parse(v))
+// readErrorClass tells a body cut off by the network, which is worth a retry,
+// from a body which can not be decoded.
+func readErrorClass(err error) string {
+	if err == io.ErrUnexpectedEOF {
+		return ERROR_NETWORK
 	}
-	dlog.Info("post paramter:%v", uparams)
-	req, err := http.NewRequest("POST", link, strings.NewReader(uparams.Encode()))
-	if err != nil {
-		return nil, err
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return ERROR_NETWORK
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
-	req.Header.Set("User-Agent", DEFAULT_USERAGENT)
-	req.Header.Set("Referer", s.LastPageUrl)
-	if header != nil {
-		for name, value := range header {
-			req.Header.Set(name, value)
-		}
+	if _, ok := err.(*net.OpError); ok {
+		return ERROR_NETWORK
 	}
+	return ERROR_PARSE
+}
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		return nil, err
+// recordResponse sets the metadata of resp in the context: _status, _headers
+// with the values of a header joined by ", ", _url, _content_type, _elapsed
+// in milliseconds and _size of the body in bytes.
+func (s *Downloader) recordResponse(resp *http.Response, elapsed time.Duration) {
+	headers := make(map[string]interface{})
+	for k, v := range resp.Header {
+		headers[k] = strings.Join(v, ", ")
 	}
-	err = s.constructPage(resp)
-	if err != nil {
-		return nil, err
+	size := len(s.LastPage)
+	if s.Stream != nil && s.LastFile != nil {
+		size = int(s.LastFile.Size)
 	}
-	s.UpdateCookieToContext(link)
-	return s.LastPage, nil
+	s.Context.Set("_status", resp.StatusCode)
+	s.Context.Set("_headers", headers)
+	s.Context.Set("_url", resp.Request.URL.String())
+	s.Context.Set("_content_type", s.LastPageContentType)
+	s.Context.Set("_elapsed", int(elapsed/time.Millisecond))
+	s.Context.Set("_size", size)
 }
 
-func (s *Downloader) PostRaw(link string, data []byte, header map[string]string) ([]byte, error) {
-	req, err := http.NewRequest("POST", link, bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
-	req.Header.Set("User-Agent", DEFAULT_USERAGENT)
-	req.Header.Set("Referer", s.LastPageUrl)
-	if header != nil {
-		for name, value := range header {
-			req.Header.Set(name, value)
-		}
-	}
+// responseKeys are the context keys set by recordResponse.
+var responseKeys = []string{"_status", "_headers", "_url", "_content_type", "_elapsed", "_size"}
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	err = s.constructPage(resp)
-	if err != nil {
-		return nil, err
+// clearResponse removes the metadata and the status of the previous
+// response, so that none of it is left when the request fails without a
+// response.
+func (s *Downloader) clearResponse() {
+	s.LastPageStatus = 0
+	for _, k := range responseKeys {
+		s.Context.Del(k)
 	}
-	s.UpdateCookieToContext(link)
-	return s.LastPage, nil
 }
 
 func (s *Downloader) UpdateCookieToContext(link string) {
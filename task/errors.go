@@ -0,0 +1,93 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ERROR_NETWORK     = "network"
+	ERROR_HTTP_STATUS = "http_status"
+	ERROR_PARSE       = "parse"
+	ERROR_EXTRACT     = "extract"
+	ERROR_CAPTCHA     = "captcha"
+	ERROR_UPLOAD      = "upload"
+	ERROR_TEMPLATE    = "template"
+
+	POLICY_CONTINUE = "continue"
+	POLICY_FAIL     = "fail"
+	POLICY_GOTO     = "goto:"
+)
+
+var errorClasses = map[string]bool{
+	ERROR_NETWORK:     true,
+	ERROR_HTTP_STATUS: true,
+	ERROR_PARSE:       true,
+	ERROR_EXTRACT:     true,
+	ERROR_CAPTCHA:     true,
+	ERROR_UPLOAD:      true,
+	ERROR_TEMPLATE:    true,
+}
+
+// defaultPolicies keeps the behavior from before on_error: a 4xx or 5xx
+// response to a GET ends the command, any other error is logged and ignored.
+// The other methods did not check the status before, so errorPolicy does not
+// apply the default http_status policy to them.
+var defaultPolicies = map[string]string{
+	ERROR_HTTP_STATUS: POLICY_FAIL,
+}
+
+// StepError is an error of a step with its class, which selects the policy
+// in the on_error of the step.
+type StepError struct {
+	Class string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Class, e.Err)
+}
+
+func newStepError(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*StepError); ok {
+		return err
+	}
+	return &StepError{Class: class, Err: err}
+}
+
+// ErrorClass returns the class of err, an untyped error is a network error
+// since those come from the http client.
+func ErrorClass(err error) string {
+	if se, ok := err.(*StepError); ok {
+		return se.Class
+	}
+	return ERROR_NETWORK
+}
+
+// errorPolicy returns the policy of class in on_error, where "*" matches all
+// classes, or the default one.
+func (s *Step) errorPolicy(class string) string {
+	if policy, ok := s.OnError[class]; ok {
+		return policy
+	}
+	if policy, ok := s.OnError["*"]; ok {
+		return policy
+	}
+	if class == ERROR_HTTP_STATUS && len(s.Method) > 0 && s.Method != "GET" {
+		return POLICY_CONTINUE
+	}
+	if policy, ok := defaultPolicies[class]; ok {
+		return policy
+	}
+	return POLICY_CONTINUE
+}
+
+func gotoTag(policy string) (string, bool) {
+	if strings.HasPrefix(policy, POLICY_GOTO) {
+		return strings.TrimPrefix(policy, POLICY_GOTO), true
+	}
+	return "", false
+}
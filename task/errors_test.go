@@ -0,0 +1,75 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/cmd"
+	hproxy "github.com/xlvector/higgs/proxy"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func runErrorTmpl(tmpl string) *cmd.Output {
+	var task Task
+	json.Unmarshal([]byte(tmpl), &task)
+	factory := NewTaskCmdFactory(nil, hproxy.NewProxyManager(""))
+	c := factory.CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &task)
+	c.SetInputArgs(map[string]string{"id": c.GetId()})
+	return c.GetMessage()
+}
+
+func TestStepErrorPolicy(t *testing.T) {
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case "/flaky":
+			n++
+			if n < 2 {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(rw, `<html><body><div id="a">ok</div></body></html>`)
+		default:
+			fmt.Fprint(rw, `<html><body><div id="a">ok</div></body></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	msg := runErrorTmpl(fmt.Sprintf(errorTmpl, ts.URL+"/down", `"#a"`, `{}`))
+	assert.Equal(t, cmd.WRONG_RESPONSE, msg.Status)
+	assert.Equal(t, ERROR_HTTP_STATUS, msg.ErrorClass)
+
+	assert.Equal(t, POLICY_CONTINUE, (&Step{Method: "POST"}).errorPolicy(ERROR_HTTP_STATUS))
+	assert.Equal(t, POLICY_FAIL, (&Step{Method: "POST", OnError: map[string]string{"*": "fail"}}).errorPolicy(ERROR_HTTP_STATUS))
+	assert.Equal(t, POLICY_FAIL, (&Step{Method: "GET"}).errorPolicy(ERROR_HTTP_STATUS))
+
+	msg = runErrorTmpl(fmt.Sprintf(errorTmpl, ts.URL+"/page", `"#b"`, `{"extract": "fail"}`))
+	assert.Equal(t, cmd.FAIL, msg.Status)
+	assert.Equal(t, ERROR_EXTRACT, msg.ErrorClass)
+
+	msg = runErrorTmpl(fmt.Sprintf(errorTmpl, ts.URL+"/flaky", `"#a"`, `{"http_status": "goto:start"}`))
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, msg.Status)
+	assert.Equal(t, `{"a":"ok"}`, msg.Data)
+	assert.Equal(t, 2, n)
+}
+
+var errorTmpl = `
+{
+    "disable_out_pub_key": true,
+    "disable_output_folder": true,
+    "steps": [
+        {
+            "tag": "start",
+            "page": "%s",
+            "doc_type": "html",
+            "extractor": {"a": %s},
+            "on_error": %s
+        }
+    ]
+}
+`
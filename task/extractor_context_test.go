@@ -16,7 +16,7 @@ func TestExtractorWithContext(t *testing.T) {
             </body>
         </html>
     `
-	c := context.NewContext(nil)
+	c := context.NewContext(nil, nil, nil)
 	ret, err := extractor.Extract([]byte(html), "div||{{contains ._v \"Hello\"}}", "html", c)
 	if err != nil {
 		t.Error(err)
@@ -0,0 +1,320 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cmd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	FIXTURE_RECORD   = "record"
+	FIXTURE_REPLAY   = "replay"
+	FIXTURE_FILENAME = "http_fixture.jsonl"
+)
+
+// FixtureEntry is one request/response pair. The values of credential
+// headers, cookies and form or json fields are replaced by REDACTED, see
+// redactEntry.
+type FixtureEntry struct {
+	Method         string      `json:"method"`
+	Url            string      `json:"url"`
+	Header         http.Header `json:"header"`
+	Body           []byte      `json:"body"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header"`
+	SetCookie      []string    `json:"set_cookie"`
+	ResponseBody   []byte      `json:"response_body"`
+	Error          string      `json:"error"`
+}
+
+// Fixture is a http.RoundTripper which records every round trip of Transport
+// to Filename (one json entry per line), or replays them from Filename
+// without touching the network.
+type Fixture struct {
+	Mode      string
+	Filename  string
+	Transport http.RoundTripper
+	entries   []*FixtureEntry
+	used      []bool
+	lock      *sync.Mutex
+}
+
+func NewFixture(mode, fname string) (*Fixture, error) {
+	ret := &Fixture{
+		Mode:     mode,
+		Filename: fname,
+		lock:     &sync.Mutex{},
+	}
+	if mode == FIXTURE_REPLAY {
+		entries, err := ReadFixtureEntries(fname)
+		if err != nil {
+			return nil, err
+		}
+		ret.entries = entries
+		ret.used = make([]bool, len(entries))
+	} else if mode != FIXTURE_RECORD {
+		return nil, errors.New("unsupported fixture mode: " + mode)
+	}
+	return ret, nil
+}
+
+func ReadFixtureEntries(fname string) ([]*FixtureEntry, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ret := []*FixtureEntry{}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e FixtureEntry
+			if jerr := json.Unmarshal(line, &e); jerr != nil {
+				return nil, jerr
+			}
+			ret = append(ret, &e)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return ret, nil
+}
+
+func (p *Fixture) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.Mode == FIXTURE_REPLAY {
+		return p.replay(req)
+	}
+	return p.record(req)
+}
+
+func (p *Fixture) record(req *http.Request) (*http.Response, error) {
+	e := &FixtureEntry{
+		Method: req.Method,
+		Url:    req.URL.String(),
+		Header: req.Header.Clone(),
+	}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		e.Body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	transport := p.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		e.Error = err.Error()
+		p.save(e)
+		return nil, err
+	}
+	e.Status = resp.StatusCode
+	e.ResponseHeader = resp.Header.Clone()
+	e.SetCookie = resp.Header["Set-Cookie"]
+	resp.Body = &fixtureBody{body: resp.Body, fixture: p, entry: e}
+	return resp, nil
+}
+
+// fixtureBody copies a response body while the step reads it, and saves the
+// entry when it is closed, so a streamed download is not read twice.
+type fixtureBody struct {
+	body    io.ReadCloser
+	buf     bytes.Buffer
+	fixture *Fixture
+	entry   *FixtureEntry
+	saved   bool
+}
+
+func (b *fixtureBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.buf.Write(p[:n])
+	if err != nil && err != io.EOF && len(b.entry.Error) == 0 {
+		b.entry.Error = err.Error()
+	}
+	return n, err
+}
+
+func (b *fixtureBody) Close() error {
+	err := b.body.Close()
+	if !b.saved {
+		b.saved = true
+		b.entry.ResponseBody = b.buf.Bytes()
+		b.fixture.save(b.entry)
+	}
+	return err
+}
+
+func (p *Fixture) save(e *FixtureEntry) {
+	redactEntry(e)
+	b, err := json.Marshal(e)
+	if err != nil {
+		dlog.Warn("marshal fixture of %s failed: %v", e.Url, err)
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	f, err := os.OpenFile(p.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		dlog.Warn("open fixture file %s failed: %v", p.Filename, err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(b, '\n'))
+}
+
+// next finds the first unused entry with the same method and url. urls often
+// carry timestamps, so it falls back to the first unused entry of the same
+// method.
+func (p *Fixture) next(req *http.Request) *FixtureEntry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	link := req.URL.String()
+	k := -1
+	for i, e := range p.entries {
+		if p.used[i] || e.Method != req.Method {
+			continue
+		}
+		if e.Url == link {
+			k = i
+			break
+		}
+		if k < 0 {
+			k = i
+		}
+	}
+	if k < 0 {
+		return nil
+	}
+	p.used[k] = true
+	if p.entries[k].Url != link {
+		dlog.Warn("replay %s with fixture of %s", link, p.entries[k].Url)
+	}
+	return p.entries[k]
+}
+
+func (p *Fixture) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	e := p.next(req)
+	if e == nil {
+		return nil, fmt.Errorf("no fixture for %s %s", req.Method, req.URL.String())
+	}
+	if len(e.Error) > 0 {
+		return nil, errors.New(e.Error)
+	}
+	header := e.ResponseHeader
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.ResponseBody)),
+		ContentLength: int64(len(e.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+const REDACTED = "REDACTED"
+
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// credentialFields are the substrings of the names of form and json fields
+// whose values are redacted.
+var credentialFields = []string{"pass", "pwd", "secret", "token", cmd.PARAM_VERIFY_CODE}
+
+func isCredentialField(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range credentialFields {
+		if strings.Contains(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCookie keeps the name and the attributes of a Set-Cookie header.
+func redactCookie(v string) string {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return v
+	}
+	j := strings.Index(v, ";")
+	if j < 0 {
+		return v[:i+1] + REDACTED
+	}
+	return v[:i+1] + REDACTED + v[j:]
+}
+
+// redactBody redacts the credential fields of a form or json object body,
+// other bodies are kept as they are.
+func redactBody(b []byte, contentType string) []byte {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return b
+		}
+		for k := range form {
+			if isCredentialField(k) {
+				form[k] = []string{REDACTED}
+			}
+		}
+		return []byte(form.Encode())
+	}
+	var obj map[string]interface{}
+	if json.Unmarshal(b, &obj) != nil {
+		return b
+	}
+	for k := range obj {
+		if isCredentialField(k) {
+			obj[k] = REDACTED
+		}
+	}
+	ret, _ := json.Marshal(obj)
+	return ret
+}
+
+// redactEntry replaces the credentials of e, so that a fixture can be kept
+// like a log. Replayed cookies get REDACTED as value.
+func redactEntry(e *FixtureEntry) {
+	for _, h := range credentialHeaders {
+		if len(e.Header.Get(h)) > 0 {
+			e.Header.Set(h, REDACTED)
+		}
+	}
+	if len(e.Body) > 0 {
+		e.Body = redactBody(e.Body, e.Header.Get("Content-Type"))
+	}
+	if len(e.SetCookie) == 0 {
+		return
+	}
+	cookies := make([]string, 0, len(e.SetCookie))
+	for _, c := range e.SetCookie {
+		cookies = append(cookies, redactCookie(c))
+	}
+	e.SetCookie = cookies
+	e.ResponseHeader["Set-Cookie"] = cookies
+}
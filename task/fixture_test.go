@@ -0,0 +1,91 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func runFixtureStep(t *testing.T, link, dir, mode string) *Downloader {
+	var step Step
+	json.Unmarshal([]byte(fmt.Sprintf(fixtureStepConfig, link)), &step)
+	d, _ := NewDownloader(nil, nil, dir, &DownloaderConfig{FixtureMode: mode}, nil)
+	d.Context.Set("query", "001")
+	err := step.Do(d, nil, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	return d
+}
+
+func TestFixture(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.SetCookie(rw, &http.Cookie{Name: "hello", Value: "world"})
+		fmt.Fprintf(rw, "<html><body><div id=\"query\">%s</div></body></html>", r.URL.Query().Get("q"))
+	}))
+	dir, _ := ioutil.TempDir("", "fixture")
+	defer os.RemoveAll(dir)
+
+	runFixtureStep(t, ts.URL, dir, FIXTURE_RECORD)
+	ts.Close()
+	entries, err := ReadFixtureEntries(dir + "/" + FIXTURE_FILENAME)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "GET", entries[0].Method)
+	assert.Equal(t, 200, entries[0].Status)
+	assert.Equal(t, []string{"hello=REDACTED"}, entries[0].SetCookie)
+	info, _ := os.Stat(dir + "/" + FIXTURE_FILENAME)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	d := runFixtureStep(t, ts.URL, dir, FIXTURE_REPLAY)
+	v, _ := d.Context.Get("q")
+	assert.Equal(t, "001", v)
+	v, _ = d.Context.Get("cookie_hello")
+	assert.Equal(t, REDACTED, v)
+
+	_, err = NewDownloader(nil, nil, dir, &DownloaderConfig{FixtureMode: FIXTURE_REPLAY, FixtureFile: dir + "/none"}, nil)
+	assert.NotNil(t, err)
+	factory := NewTaskCmdFactory(nil, nil)
+	factory.SetFixture(FIXTURE_REPLAY, dir+"/none")
+	assert.Nil(t, factory.CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &Task{DisableOutPubKey: true, DisableOutputFolder: true}))
+}
+
+var fixtureStepConfig = `
+{
+    "page": "%s/first?q={{.query}}",
+    "doc_type": "html",
+    "context_opers": [
+        "{{extractHtml ._body \"#query\" | set \"q\"}}"
+    ]
+}
+`
+
+func TestRedactEntry(t *testing.T) {
+	e := &FixtureEntry{
+		Header: http.Header{
+			"Authorization": {"Basic YTpi"},
+			"Content-Type":  {"application/x-www-form-urlencoded"},
+		},
+		Body:           []byte("user=a&password=secret&randcode=1234"),
+		SetCookie:      []string{"sid=abc; Path=/; HttpOnly"},
+		ResponseHeader: http.Header{"Set-Cookie": {"sid=abc; Path=/; HttpOnly"}},
+	}
+	redactEntry(e)
+	assert.Equal(t, REDACTED, e.Header.Get("Authorization"))
+	assert.Equal(t, "password=REDACTED&randcode=REDACTED&user=a", string(e.Body))
+	assert.Equal(t, []string{"sid=REDACTED; Path=/; HttpOnly"}, e.SetCookie)
+	assert.Equal(t, e.SetCookie, e.ResponseHeader["Set-Cookie"])
+
+	e = &FixtureEntry{Header: http.Header{}, Body: []byte(`{"user":"a","passWord":"secret"}`)}
+	redactEntry(e)
+	assert.Equal(t, `{"passWord":"REDACTED","user":"a"}`, string(e.Body))
+}
@@ -0,0 +1,51 @@
+package task
+
+import (
+	"github.com/xlvector/higgs/config"
+	"math/rand"
+	"net/http"
+)
+
+// PickUserAgent returns a random user agent of p, or DEFAULT_USERAGENT when p
+// has none.
+func PickUserAgent(p *config.HeaderProfile) string {
+	if p == nil || len(p.UserAgents) == 0 {
+		return DEFAULT_USERAGENT
+	}
+	return p.UserAgents[rand.Intn(len(p.UserAgents))]
+}
+
+func (s *Downloader) userAgent() string {
+	if s.Headers != nil && s.Headers.Rotate && s.Context.CJS == nil {
+		return PickUserAgent(s.Headers)
+	}
+	if len(s.UserAgent) == 0 {
+		return DEFAULT_USERAGENT
+	}
+	return s.UserAgent
+}
+
+// setProfileHeaders sets the user agent and the headers of the header profile
+// of the downloader on req.
+func (s *Downloader) setProfileHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", s.userAgent())
+	p := s.Headers
+	if p == nil {
+		return
+	}
+	named := [][2]string{
+		{"Accept", p.Accept},
+		{"Accept-Language", p.AcceptLanguage},
+		{"Sec-Ch-Ua", p.SecChUa},
+		{"Sec-Ch-Ua-Mobile", p.SecChUaMobile},
+		{"Sec-Ch-Ua-Platform", p.SecChUaPlatform},
+	}
+	for _, h := range named {
+		if len(h[1]) > 0 {
+			req.Header.Set(h[0], h[1])
+		}
+	}
+	for name, value := range p.Headers {
+		req.Header.Set(name, value)
+	}
+}
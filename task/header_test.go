@@ -0,0 +1,48 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderProfile(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer ts.Close()
+
+	d, _ := NewDownloader(nil, nil, "", nil, nil)
+	d.Get(ts.URL, nil)
+	assert.Equal(t, DEFAULT_USERAGENT, got.Get("User-Agent"))
+
+	profile := &config.HeaderProfile{
+		UserAgents:     []string{"ua-1", "ua-2", "ua-3"},
+		AcceptLanguage: "zh-CN,zh;q=0.9",
+		SecChUa:        `"Chromium";v="118"`,
+		Headers:        map[string]string{"X-Extra": "1"},
+	}
+	d, _ = NewDownloader(nil, nil, "", &DownloaderConfig{Headers: profile, UserAgent: PickUserAgent(profile)}, nil)
+	d.Get(ts.URL, map[string]string{"X-Extra": "2"})
+	ua := got.Get("User-Agent")
+	assert.Contains(t, profile.UserAgents, ua)
+	assert.Equal(t, "zh-CN,zh;q=0.9", got.Get("Accept-Language"))
+	assert.Equal(t, `"Chromium";v="118"`, got.Get("Sec-Ch-Ua"))
+	assert.Equal(t, "", got.Get("Sec-Ch-Ua-Mobile"))
+	assert.Equal(t, "2", got.Get("X-Extra"))
+	for i := 0; i < 10; i++ {
+		d.Get(ts.URL, nil)
+		assert.Equal(t, ua, got.Get("User-Agent"))
+	}
+
+	profile.Rotate = true
+	seen := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		d.Get(ts.URL, nil)
+		seen[got.Get("User-Agent")] = true
+	}
+	assert.True(t, len(seen) > 1)
+}
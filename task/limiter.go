@@ -0,0 +1,141 @@
+package task
+
+import (
+	"github.com/xlvector/higgs/config"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_QUEUE_CHECK = time.Second
+)
+
+// rateWaitReport is how long a wait for a token must be to be reported to
+// the caller of Wait.
+var rateWaitReport = time.Second
+
+// Limiter is shared by all Downloaders and commands, its limits are read from
+// config.Get() at each call so that they follow config reloads.
+var Limiter = NewRateLimiter()
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	buckets  map[string]*bucket
+	sessions map[string]int
+	update   chan struct{}
+	lock     *sync.Mutex
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:  make(map[string]*bucket),
+		sessions: make(map[string]int),
+		update:   make(chan struct{}),
+		lock:     &sync.Mutex{},
+	}
+}
+
+// reserve takes a token of key and returns how long to wait for it, it must
+// be called with lock held.
+func (p *RateLimiter) reserve(key string, limit *config.RateLimit, now time.Time) time.Duration {
+	if limit == nil || limit.Rps <= 0 {
+		return 0
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	b, ok := p.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		p.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rps
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / limit.Rps * float64(time.Second))
+}
+
+// Reserve takes a token of the limits of tmpl and host, and returns how long
+// to wait before sending the request.
+func (p *RateLimiter) Reserve(tmpl, host string) time.Duration {
+	conf := config.Get()
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	wait := p.reserve("tmpl:"+tmpl, conf.TmplLimits[tmpl], now)
+	if w := p.reserve("host:"+host, conf.HostLimits[host], now); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// Wait sleeps until the token of Reserve is due, waiting is called before
+// the sleep when it is longer than rateWaitReport.
+func (p *RateLimiter) Wait(tmpl, host string, waiting func(time.Duration)) {
+	wait := p.Reserve(tmpl, host)
+	if wait > rateWaitReport && waiting != nil {
+		waiting(wait)
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (p *RateLimiter) tryAcquire(tmpl string) (bool, chan struct{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	limit := config.Get().TmplLimits[tmpl]
+	if limit != nil && limit.MaxSessions > 0 && p.sessions[tmpl] >= limit.MaxSessions {
+		return false, p.update
+	}
+	p.sessions[tmpl]++
+	return true, nil
+}
+
+// Acquire blocks until a session of tmpl is available. queued is called once
+// if it has to wait, and it gives up when cancelled returns true.
+func (p *RateLimiter) Acquire(tmpl string, queued func(), cancelled func() bool) bool {
+	for first := true; ; first = false {
+		ok, update := p.tryAcquire(tmpl)
+		if ok {
+			return true
+		}
+		if first && queued != nil {
+			queued()
+		}
+		if cancelled != nil && cancelled() {
+			return false
+		}
+		select {
+		case <-update:
+		case <-time.After(SESSION_QUEUE_CHECK):
+		}
+	}
+}
+
+func (p *RateLimiter) Release(tmpl string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.sessions[tmpl] > 0 {
+		p.sessions[tmpl]--
+	}
+	close(p.update)
+	p.update = make(chan struct{})
+}
+
+func (p *RateLimiter) Sessions(tmpl string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.sessions[tmpl]
+}
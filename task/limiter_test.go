@@ -0,0 +1,118 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	prev := config.Get()
+	defer config.Set(prev)
+	config.Set(&config.Config{
+		HostLimits: map[string]*config.RateLimit{"a.com": {Rps: 10, Burst: 2}},
+		TmplLimits: map[string]*config.RateLimit{"mock": {MaxSessions: 1}},
+	})
+
+	l := NewRateLimiter()
+	assert.Equal(t, time.Duration(0), l.Reserve("mock", "a.com"))
+	assert.Equal(t, time.Duration(0), l.Reserve("mock", "a.com"))
+	wait := l.Reserve("mock", "a.com")
+	assert.True(t, wait > 90*time.Millisecond && wait <= 100*time.Millisecond)
+	assert.Equal(t, time.Duration(0), l.Reserve("mock", "b.com"))
+
+	assert.True(t, l.Acquire("mock", nil, nil))
+	queued := make(chan bool, 2)
+	done := make(chan bool)
+	go func() {
+		done <- l.Acquire("mock", func() { queued <- true }, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, len(queued))
+	l.Release("mock")
+	assert.True(t, <-done)
+	assert.Equal(t, 1, l.Sessions("mock"))
+
+	assert.False(t, l.Acquire("mock", nil, func() bool { return true }))
+	assert.True(t, l.Acquire("other", nil, nil))
+}
+
+func TestRateWaitReported(t *testing.T) {
+	prev := config.Get()
+	defer config.Set(prev)
+	defer func(prev time.Duration) { rateWaitReport = prev }(rateWaitReport)
+	rateWaitReport = 10 * time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "<html><body><div id=\"code\">1</div></body></html>")
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	config.Set(&config.Config{HostLimits: map[string]*config.RateLimit{u.Hostname(): {Rps: 10, Burst: 1}}})
+
+	var task Task
+	json.Unmarshal([]byte(fmt.Sprintf(rateTmpl, ts.URL, ts.URL)), &task)
+	c := NewTaskCmdFactory(nil, nil).CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &task)
+	c.SetInputArgs(map[string]string{"id": c.GetId()})
+	msg := c.GetMessage()
+	assert.Equal(t, cmd.QUEUED, msg.Status)
+	assert.Contains(t, msg.Data, "for the rate limit of "+u.Hostname())
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, c.GetMessage().Status)
+}
+
+func TestSessionReleasedWhileWaiting(t *testing.T) {
+	prev := config.Get()
+	defer config.Set(prev)
+	config.Set(&config.Config{TmplLimits: map[string]*config.RateLimit{"mock": {MaxSessions: 1}}})
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, "<html><body><div id=\"code\">%s</div></body></html>", r.URL.Query().Get("code"))
+	}))
+	defer ts.Close()
+
+	var task Task
+	json.Unmarshal([]byte(fmt.Sprintf(waitingTmpl, ts.URL)), &task)
+	factory := NewTaskCmdFactory(nil, nil)
+	c1 := factory.CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &task)
+	c1.SetInputArgs(map[string]string{"id": c1.GetId()})
+	assert.Equal(t, cmd.NEED_PARAM, c1.GetMessage().Status)
+	assert.Equal(t, 0, Limiter.Sessions("mock"))
+
+	c2 := factory.CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &task)
+	c2.SetInputArgs(map[string]string{"id": c2.GetId(), "randcode": "2"})
+	msg := c2.GetMessage()
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, msg.Status)
+	assert.Equal(t, `{"code":"2"}`, msg.Data)
+
+	c1.SetInputArgs(map[string]string{"id": c1.GetId(), "randcode": "1"})
+	msg = c1.GetMessage()
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, msg.Status)
+	assert.Equal(t, `{"code":"1"}`, msg.Data)
+}
+
+var rateTmpl = `
+{
+    "disable_out_pub_key": true,
+    "disable_output_folder": true,
+    "steps": [
+        {"page": "%s"},
+        {"page": "%s", "doc_type": "html", "extractor": {"code": "#code"}}
+    ]
+}
+`
+
+var waitingTmpl = `
+{
+    "disable_out_pub_key": true,
+    "disable_output_folder": true,
+    "steps": [
+        {"need_param": "randcode"},
+        {"page": "%s?code={{.randcode}}", "doc_type": "html", "extractor": {"code": "#code"}}
+    ]
+}
+`
@@ -5,38 +5,100 @@ import (
 	"github.com/xlvector/dlog"
 	"github.com/xlvector/higgs/config"
 	"io/ioutil"
+	"path"
 	"strings"
+	"sync"
 )
 
 type TaskManager struct {
 	tasks   map[string]*Task
+	errors  map[string][]*TemplateError
 	rootDir string
+	lock    *sync.RWMutex
+}
+
+func newEmptyTaskManager(root string) *TaskManager {
+	return &TaskManager{
+		tasks:   make(map[string]*Task),
+		errors:  make(map[string][]*TemplateError),
+		rootDir: root,
+		lock:    &sync.RWMutex{},
+	}
 }
 
 func NewTaskManager(root string) *TaskManager {
-	dir, err := ioutil.ReadDir(root)
+	ret := newEmptyTaskManager(root)
+	err := ret.Reload()
 	if err != nil {
 		dlog.Warn("can not find etc folder")
 	}
-	ret := &TaskManager{
-		tasks:   make(map[string]*Task),
-		rootDir: root,
+	return ret
+}
+
+// Reload reads all templates in rootDir again, fixes includes, validates them
+// and then swaps them in. A template which becomes invalid keeps its previous
+// version. Running commands are not affected since they use a DeepCopy.
+func (p *TaskManager) Reload() error {
+	dir, err := ioutil.ReadDir(p.rootDir)
+	if err != nil {
+		return err
 	}
+	next := newEmptyTaskManager(p.rootDir)
 	for _, f := range dir {
 		if strings.HasSuffix(f.Name(), ".json") {
-			task := NewTask("./etc/tmpls/" + f.Name())
-			ret.tasks[f.Name()] = task
+			task := NewTask(path.Join(p.rootDir, f.Name()))
+			if task == nil {
+				next.addErrors(f.Name(), []*TemplateError{&TemplateError{
+					File:    f.Name(),
+					Step:    -1,
+					Message: "fail to load template",
+				}})
+				continue
+			}
+			next.tasks[f.Name()] = task
 		}
 	}
-	ret.FixInclude()
-	return ret
+	for name, task := range next.tasks {
+		next.addErrors(name, next.ValidateRequire(name, task))
+	}
+	next.FixInclude()
+	for name, task := range next.tasks {
+		next.addErrors(name, next.Validate(name, task))
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for name, errs := range next.errors {
+		for _, err := range errs {
+			dlog.Warn("invalid template: %v", err)
+		}
+		delete(next.tasks, name)
+		if prev, ok := p.tasks[name]; ok {
+			dlog.Warn("keep previous version of template %s", name)
+			next.tasks[name] = prev
+		}
+	}
+	p.tasks = next.tasks
+	p.errors = next.errors
+	return nil
+}
+
+func (p *TaskManager) addErrors(name string, errs []*TemplateError) {
+	if len(errs) > 0 {
+		p.errors[name] = append(p.errors[name], errs...)
+	}
+}
+
+// Errors returns the errors of the templates rejected at the last load.
+func (p *TaskManager) Errors() map[string][]*TemplateError {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.errors
 }
 
 func (p *TaskManager) Get(tmpl string) *Task {
-	if name, ok := config.Instance.Templates[tmpl]; ok {
-		if task, ok2 := p.tasks[name]; ok2 {
-			return task.DeepCopy()
-		}
+	if name, ok := config.Get().Templates[tmpl]; ok {
+		return p.GetByName(name)
 	} else {
 		dlog.Warn("fail to find name for tmpl %s", tmpl)
 	}
@@ -44,6 +106,8 @@ func (p *TaskManager) Get(tmpl string) *Task {
 }
 
 func (p *TaskManager) GetByName(name string) *Task {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	if task, ok := p.tasks[name]; ok {
 		return task.DeepCopy()
 	}
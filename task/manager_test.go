@@ -0,0 +1,29 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tmpls")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/base.json", []byte(baseTmpl), 0644)
+
+	tm := NewTaskManager(dir)
+	prev := tm.GetByName("base.json")
+	assert.NotNil(t, prev)
+
+	ioutil.WriteFile(dir+"/base.json", []byte(badTmpl), 0644)
+	ioutil.WriteFile(dir+"/new.json", []byte(baseTmpl), 0644)
+	err := tm.Reload()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, prev, tm.GetByName("base.json"))
+	assert.NotNil(t, tm.GetByName("new.json"))
+	assert.True(t, len(tm.Errors()["base.json"]) > 0)
+}
@@ -0,0 +1,191 @@
+package task
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/xlvector/higgs/config"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DEFAULT_CLIENT_TIMEOUT = 30
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func cipherSuiteIds(names []string) ([]uint16, error) {
+	ids := make(map[string]uint16)
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids[c.Name] = c.ID
+	}
+	ret := []uint16{}
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, errors.New("unknown cipher suite " + name)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+func tlsVersion(v string) (uint16, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	if ret, ok := tlsVersions[v]; ok {
+		return ret, nil
+	}
+	return 0, errors.New("unknown tls version " + v)
+}
+
+// verifyPins accepts a connection when a certificate of the verified chains
+// matches one of pins. Without verification the other certificates sent by
+// the server prove nothing, so only the leaf one is checked.
+func verifyPins(pins []string, insecure bool) func([][]byte, [][]*x509.Certificate) error {
+	accept := make(map[string]bool)
+	for _, pin := range pins {
+		accept[pin] = true
+	}
+	match := func(cert *x509.Certificate) bool {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return accept[base64.StdEncoding.EncodeToString(sum[:])]
+	}
+	return func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if insecure {
+			if len(rawCerts) > 0 {
+				cert, err := x509.ParseCertificate(rawCerts[0])
+				if err == nil && match(cert) {
+					return nil
+				}
+			}
+			return errors.New("no certificate matches the pins")
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if match(cert) {
+					return nil
+				}
+			}
+		}
+		return errors.New("no certificate matches the pins")
+	}
+}
+
+func NewTLSConfig(p *config.ClientProfile) (*tls.Config, error) {
+	ret := &tls.Config{
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+	var err error
+	if ret.MinVersion, err = tlsVersion(p.MinVersion); err != nil {
+		return nil, err
+	}
+	if ret.MaxVersion, err = tlsVersion(p.MaxVersion); err != nil {
+		return nil, err
+	}
+	if len(p.CipherSuites) > 0 {
+		if ret.CipherSuites, err = cipherSuiteIds(p.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.RootCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, f := range p.RootCAs {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no certificate in %s", f)
+			}
+		}
+		ret.RootCAs = pool
+	}
+	if len(p.ClientCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(p.ClientCert, p.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+	if len(p.Pins) > 0 {
+		ret.VerifyPeerCertificate = verifyPins(p.Pins, p.InsecureSkipVerify)
+	}
+	if p.HTTP2 {
+		ret.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return ret, nil
+}
+
+func seconds(n, def int) time.Duration {
+	if n <= 0 {
+		n = def
+	}
+	return time.Duration(n) * time.Second
+}
+
+// NewTransport returns the transport of a client profile, or the legacy one
+// of all commands when p is nil.
+func NewTransport(p *config.ClientProfile) (*http.Transport, error) {
+	if p == nil {
+		return legacyTransport(), nil
+	}
+	tc, err := NewTLSConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	ret := &http.Transport{
+		TLSClientConfig:       tc,
+		DisableKeepAlives:     !p.KeepAlive,
+		ForceAttemptHTTP2:     p.HTTP2,
+		TLSHandshakeTimeout:   seconds(p.TLSHandshakeTimeout, 10),
+		ResponseHeaderTimeout: seconds(p.ResponseHeaderTimeout, DEFAULT_CLIENT_TIMEOUT),
+		DialContext: (&net.Dialer{
+			Timeout:   seconds(p.DialTimeout, DEFAULT_CLIENT_TIMEOUT),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+	}
+	if !p.HTTP2 {
+		ret.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return ret, nil
+}
+
+func legacyTransport() *http.Transport {
+	return &http.Transport{
+		DisableKeepAlives:     true,
+		ResponseHeaderTimeout: time.Second * 30,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+			MinVersion:         tls.VersionTLS10,
+			CipherSuites: []uint16{
+				tls.TLS_RSA_WITH_RC4_128_SHA,
+				tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+				tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			},
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/config"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientProfile(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, "%s %x", r.Proto, r.TLS.Version)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	cert := ts.Certificate()
+	f, _ := ioutil.TempFile("", "ca")
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	f.Close()
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	get := func(p *config.ClientProfile) (string, error) {
+		transport, err := NewTransport(p)
+		if err != nil {
+			return "", err
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(ts.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b), nil
+	}
+
+	body, err := get(&config.ClientProfile{MinVersion: "1.3", RootCAs: []string{f.Name()}, HTTP2: true})
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("HTTP/2.0 %x", tls.VersionTLS13), body)
+
+	body, err = get(&config.ClientProfile{MaxVersion: "1.2", RootCAs: []string{f.Name()}, KeepAlive: true})
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 %x", tls.VersionTLS12), body)
+
+	_, err = get(&config.ClientProfile{})
+	assert.NotNil(t, err)
+	_, err = get(&config.ClientProfile{InsecureSkipVerify: true, Pins: []string{pin}})
+	assert.Nil(t, err)
+	_, err = get(&config.ClientProfile{InsecureSkipVerify: true, Pins: []string{"bad"}})
+	assert.NotNil(t, err)
+	_, err = get(&config.ClientProfile{RootCAs: []string{f.Name()}, Pins: []string{pin}})
+	assert.Nil(t, err)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leaf, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{SerialNumber: big.NewInt(1)},
+		&x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	verify := verifyPins([]string{pin}, true)
+	assert.NotNil(t, verify([][]byte{leaf, cert.Raw}, nil))
+	assert.Nil(t, verify([][]byte{cert.Raw, leaf}, nil))
+	verify = verifyPins([]string{pin}, false)
+	assert.NotNil(t, verify([][]byte{leaf, cert.Raw}, nil))
+
+	_, err = NewTransport(&config.ClientProfile{CipherSuites: []string{"TLS_NONE"}})
+	assert.NotNil(t, err)
+	_, err = NewTransport(&config.ClientProfile{MinVersion: "2.0"})
+	assert.NotNil(t, err)
+}
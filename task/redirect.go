@@ -0,0 +1,73 @@
+package task
+
+import (
+	"github.com/xlvector/dlog"
+	"net/http"
+)
+
+const (
+	DEFAULT_MAX_REDIRECTS = 10
+)
+
+// Redirect is the redirect policy of a step. Disable returns the redirect
+// response itself, MaxHops stops following after that many redirects and
+// returns the last response. KeepMethod false turns 307 and 308 into a GET
+// without body like 302, by default they keep the method and the body.
+type Redirect struct {
+	Disable    bool  `json:"disable"`
+	MaxHops    int   `json:"max_hops"`
+	KeepMethod *bool `json:"keep_method"`
+}
+
+func (p *Redirect) maxHops() int {
+	if p == nil || p.MaxHops <= 0 {
+		return DEFAULT_MAX_REDIRECTS
+	}
+	return p.MaxHops
+}
+
+func (p *Redirect) keepMethod() bool {
+	return p == nil || p.KeepMethod == nil || *p.KeepMethod
+}
+
+// checkRedirect is the CheckRedirect of the client of the downloader, it
+// records the redirect chain and applies the Redirect of the current step.
+func (s *Downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	dlog.Warn("CheckRedirect URL:%s", req.URL.String())
+	if s.Redirect != nil && s.Redirect.Disable {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > s.Redirect.maxHops() {
+		dlog.Warn("stop after %d redirects", len(via)-1)
+		return http.ErrUseLastResponse
+	}
+	if !s.Redirect.keepMethod() && req.Response != nil {
+		if code := req.Response.StatusCode; code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect {
+			req.Method = "GET"
+			req.Body = nil
+			req.GetBody = nil
+			req.ContentLength = 0
+			req.Header.Del("Content-Type")
+		}
+	}
+	s.Redirects = append(s.Redirects, via[len(via)-1].URL.String())
+	s.LastLocation = req.URL.String()
+	return nil
+}
+
+// recordRedirects sets _redirects and _location in the context after resp,
+// a redirect response which was not followed gives the last location.
+func (s *Downloader) recordRedirects(resp *http.Response) {
+	if resp.StatusCode/100 == 3 {
+		if loc, err := resp.Location(); err == nil {
+			s.Redirects = append(s.Redirects, resp.Request.URL.String())
+			s.LastLocation = loc.String()
+		}
+	}
+	redirects := make([]interface{}, 0, len(s.Redirects))
+	for _, r := range s.Redirects {
+		redirects = append(redirects, r)
+	}
+	s.Context.Set("_redirects", redirects)
+	s.Context.Set("_location", s.LastLocation)
+}
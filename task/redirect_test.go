@@ -0,0 +1,62 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStepRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.Redirect(rw, r, "/sso?ticket=abc", http.StatusFound)
+		case "/sso":
+			http.Redirect(rw, r, "/home", http.StatusFound)
+		case "/submit":
+			http.Redirect(rw, r, "/echo", http.StatusTemporaryRedirect)
+		default:
+			b, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(rw, "%s %s %s", r.Method, r.URL.Path, b)
+		}
+	}))
+	defer ts.Close()
+
+	do := func(conf string) *Downloader {
+		var step Step
+		json.Unmarshal([]byte(fmt.Sprintf(conf, ts.URL)), &step)
+		d, _ := NewDownloader(nil, nil, "", nil, nil)
+		assert.Nil(t, step.Do(d, nil, nil))
+		return d
+	}
+
+	d := do(`{"page": "%s/login"}`)
+	assert.Equal(t, "GET /home ", string(d.LastPage))
+	v, _ := d.Context.Get("_redirects")
+	assert.Equal(t, []interface{}{ts.URL + "/login", ts.URL + "/sso?ticket=abc"}, v)
+	v, _ = d.Context.Get("_location")
+	assert.Equal(t, ts.URL+"/home", v)
+
+	d = do(`{"page": "%s/login", "redirect": {"disable": true}}`)
+	assert.Equal(t, 302, d.LastPageStatus)
+	v, _ = d.Context.Get("_location")
+	assert.Equal(t, ts.URL+"/sso?ticket=abc", v)
+
+	d = do(`{"page": "%s/login", "redirect": {"max_hops": 1}}`)
+	v, _ = d.Context.Get("_location")
+	assert.Equal(t, ts.URL+"/home", v)
+	assert.Equal(t, ts.URL+"/sso?ticket=abc", d.LastPageUrl)
+
+	d = do(`{"page": "%s/submit", "method": "PUT", "body": "data"}`)
+	assert.Equal(t, "PUT /echo data", string(d.LastPage))
+	d = do(`{"page": "%s/submit", "method": "PUT", "body": "data", "redirect": {"keep_method": false}}`)
+	assert.Equal(t, "GET /echo ", string(d.LastPage))
+
+	d = do(`{"page": "%s/home"}`)
+	v, _ = d.Context.Get("_redirects")
+	assert.Equal(t, []interface{}{}, v)
+}
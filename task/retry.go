@@ -0,0 +1,130 @@
+package task
+
+import (
+	hproxy "github.com/xlvector/higgs/proxy"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY_BACKOFF     = 500
+	DEFAULT_RETRY_MAX_BACKOFF = 30000
+)
+
+var defaultRetryStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// HttpRetry is the http_retry of a step. A request is sent at most Attempts
+// times when it gets a network error or a status in Status, waiting Backoff
+// milliseconds first and twice as long after each attempt, up to MaxBackoff.
+// Jitter randomizes each wait by that fraction. The Retry-After header of the
+// response replaces the wait unless IgnoreRetryAfter, it is still capped by
+// MaxBackoff. SwitchProxy blocks the proxy of the command and picks a new one
+// from the ProxyManager before each retry.
+type HttpRetry struct {
+	Attempts         int     `json:"attempts"`
+	Backoff          int     `json:"backoff"`
+	MaxBackoff       int     `json:"max_backoff"`
+	Jitter           float64 `json:"jitter"`
+	Status           []int   `json:"status"`
+	IgnoreRetryAfter bool    `json:"ignore_retry_after"`
+	SwitchProxy      bool    `json:"switch_proxy"`
+}
+
+func (r *HttpRetry) retryStatus(status int) bool {
+	codes := r.Status
+	if len(codes) == 0 {
+		codes = defaultRetryStatus
+	}
+	for _, c := range codes {
+		if c == status {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns how long to wait before the next attempt, and false when the
+// request should not be sent again.
+func (r *HttpRetry) wait(attempt, status int, err error, retryAfter string) (time.Duration, bool) {
+	if r == nil || attempt >= r.Attempts {
+		return 0, false
+	}
+	if err != nil {
+		if ErrorClass(err) != ERROR_NETWORK {
+			return 0, false
+		}
+	} else if !r.retryStatus(status) {
+		return 0, false
+	}
+
+	backoff, maxBackoff := r.Backoff, r.MaxBackoff
+	if backoff <= 0 {
+		backoff = DEFAULT_RETRY_BACKOFF
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	max := time.Duration(maxBackoff) * time.Millisecond
+	if d, ok := parseRetryAfter(retryAfter); ok && !r.IgnoreRetryAfter {
+		if d > max {
+			d = max
+		}
+		return d, true
+	}
+	d := time.Duration(backoff) * time.Millisecond
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if r.Jitter > 0 {
+		d += time.Duration(float64(d) * r.Jitter * (rand.Float64()*2 - 1))
+	}
+	if d > max {
+		d = max
+	}
+	return d, true
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as a http date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := t.Sub(time.Now())
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// switchProxy blocks the current proxy for the template and moves to a new
+// one, it keeps the current proxy when there is no other.
+func (s *Downloader) switchProxy() {
+	pm := s.Context.ProxyManager
+	if pm == nil {
+		return
+	}
+	name := s.tmpl()
+	if len(name) == 0 {
+		name = hproxy.DEFAULT_TMPL
+	}
+	pm.BlockTmplProxy(name, s.Context.Proxy)
+	next := pm.GetTmplProxy(name)
+	if next == nil {
+		return
+	}
+	s.Context.Proxy = next
+	s.SetProxy(next)
+}
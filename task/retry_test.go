@@ -0,0 +1,93 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpRetryWait(t *testing.T) {
+	var r *HttpRetry
+	_, ok := r.wait(1, 503, nil, "")
+	assert.False(t, ok)
+
+	r = &HttpRetry{Attempts: 4, Backoff: 100, MaxBackoff: 300}
+	d, ok := r.wait(1, 503, nil, "")
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, d)
+	d, _ = r.wait(2, 0, newStepError(ERROR_NETWORK, errors.New("timeout")), "")
+	assert.Equal(t, 200*time.Millisecond, d)
+	d, _ = r.wait(3, 429, nil, "")
+	assert.Equal(t, 300*time.Millisecond, d)
+	_, ok = r.wait(4, 503, nil, "")
+	assert.False(t, ok)
+	_, ok = r.wait(1, 404, nil, "")
+	assert.False(t, ok)
+	_, ok = r.wait(1, 0, newStepError(ERROR_PARSE, errors.New("bad gzip")), "")
+	assert.False(t, ok)
+
+	d, _ = r.wait(1, 503, nil, "0")
+	assert.Equal(t, time.Duration(0), d)
+	d, _ = r.wait(1, 503, nil, "120")
+	assert.Equal(t, 300*time.Millisecond, d)
+
+	r.Jitter = 0.5
+	d, _ = r.wait(1, 503, nil, "")
+	assert.True(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond)
+}
+
+func TestRequestRetry(t *testing.T) {
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			rw.Header().Set("Retry-After", "0")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ParseForm()
+		fmt.Fprint(rw, r.FormValue("a"))
+	}))
+	defer ts.Close()
+
+	d, _ := NewDownloader(nil, nil, "", nil, nil)
+	d.Retry = &HttpRetry{Attempts: 3}
+	b, err := d.Post(ts.URL, map[string]string{"a": "b"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(b))
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 200, d.LastPageStatus)
+}
+
+func TestRequestRetryCutBody(t *testing.T) {
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n++
+		if n < 3 {
+			rw.Header().Set("Content-Length", "100")
+			fmt.Fprint(rw, "cut")
+			return
+		}
+		fmt.Fprint(rw, "whole")
+	}))
+	defer ts.Close()
+
+	d, _ := NewDownloader(nil, nil, "", nil, nil)
+	b, err := d.Get(ts.URL, nil)
+	assert.Equal(t, ERROR_NETWORK, ErrorClass(err))
+
+	d.Retry = &HttpRetry{Attempts: 2, Backoff: 1}
+	b, err = d.Get(ts.URL, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "whole", string(b))
+	assert.Equal(t, 3, n)
+
+	ts.Close()
+	_, err = d.Get(ts.URL, nil)
+	assert.Equal(t, ERROR_NETWORK, ErrorClass(err))
+	assert.Equal(t, 0, d.LastPageStatus)
+}
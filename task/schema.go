@@ -0,0 +1,174 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// Schema describes the extractor results of a task with the JSON Schema
+// keywords type, required, properties, items, pattern, minItems and maxItems.
+// A required field should be neither null nor "", since the extractor gives
+// them when the selectors match nothing. A null value, as well as a missing
+// field, has no items for minItems. Other checks, type included, skip null
+// and missing values, so required is the way to reject them.
+type Schema struct {
+	Type       string             `json:"type,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Pattern    string             `json:"pattern,omitempty"`
+	MinItems   *int               `json:"minItems,omitempty"`
+	MaxItems   *int               `json:"maxItems,omitempty"`
+}
+
+// SchemaViolation is a value at Path, like $.bills[0].amount, which does not
+// match its schema.
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+var schemaTypes = map[string]bool{
+	"":        true,
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// Check returns the first error of s itself, an unknown type or a bad pattern.
+func (s *Schema) Check() error {
+	if !schemaTypes[s.Type] {
+		return errors.New("unknown type " + s.Type)
+	}
+	if len(s.Pattern) > 0 {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	if s.MinItems != nil && s.MaxItems != nil && *s.MinItems > *s.MaxItems {
+		return fmt.Errorf("minItems %d greater than maxItems %d", *s.MinItems, *s.MaxItems)
+	}
+	for _, k := range sortedKeys(s.Properties) {
+		if err := s.Properties[k].Check(); err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.Check(); err != nil {
+			return fmt.Errorf("items: %v", err)
+		}
+	}
+	return nil
+}
+
+// Validate returns the violations of v against s.
+func (s *Schema) Validate(v interface{}) []*SchemaViolation {
+	ret := []*SchemaViolation{}
+	s.validate("$", v, &ret)
+	return ret
+}
+
+func sortedKeys(m map[string]*Schema) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func schemaType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case int, int64:
+		return "integer"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}, []string:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func isEmptyValue(v interface{}) bool {
+	s, ok := v.(string)
+	return v == nil || ok && len(s) == 0
+}
+
+func (s *Schema) validate(path string, v interface{}, ret *[]*SchemaViolation) {
+	add := func(format string, args ...interface{}) {
+		*ret = append(*ret, &SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if v == nil {
+		if s.MinItems != nil && *s.MinItems > 0 {
+			add("null less than minItems %d", *s.MinItems)
+		}
+		return
+	}
+	t := schemaType(v)
+	if len(s.Type) > 0 && s.Type != t && !(s.Type == "number" && t == "integer") {
+		add("expect %s but get %s", s.Type, t)
+		return
+	}
+
+	switch val := v.(type) {
+	case string:
+		if len(s.Pattern) > 0 {
+			reg, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				add("bad pattern %s: %v", s.Pattern, err)
+			} else if !reg.MatchString(val) {
+				add("%q does not match %s", val, s.Pattern)
+			}
+		}
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if isEmptyValue(val[k]) {
+				*ret = append(*ret, &SchemaViolation{Path: path + "." + k, Message: "required"})
+			}
+		}
+		for _, k := range sortedKeys(s.Properties) {
+			s.Properties[k].validate(path+"."+k, val[k], ret)
+		}
+	case []interface{}:
+		s.validateItems(path, val, add, ret)
+	case []string:
+		items := make([]interface{}, 0, len(val))
+		for _, e := range val {
+			items = append(items, e)
+		}
+		s.validateItems(path, items, add, ret)
+	}
+}
+
+func (s *Schema) validateItems(path string, items []interface{}, add func(string, ...interface{}), ret *[]*SchemaViolation) {
+	if s.MinItems != nil && len(items) < *s.MinItems {
+		add("%d items less than minItems %d", len(items), *s.MinItems)
+	}
+	if s.MaxItems != nil && len(items) > *s.MaxItems {
+		add("%d items more than maxItems %d", len(items), *s.MaxItems)
+	}
+	if s.Items == nil {
+		return
+	}
+	for i, e := range items {
+		s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, ret)
+	}
+}
@@ -0,0 +1,106 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var billSchema = `
+{
+    "type": "object",
+    "required": ["name", "bills"],
+    "properties": {
+        "name": {"type": "string", "pattern": "^[^0-9]+$"},
+        "bills": {
+            "type": "array",
+            "minItems": 1,
+            "maxItems": 2,
+            "items": {
+                "type": "object",
+                "required": ["amount"],
+                "properties": {"amount": {"type": "number"}, "month": {"type": "integer"}}
+            }
+        }
+    }
+}
+`
+
+func TestSchema(t *testing.T) {
+	var s Schema
+	assert.NoError(t, json.Unmarshal([]byte(billSchema), &s))
+	assert.NoError(t, s.Check())
+
+	var v interface{}
+	json.Unmarshal([]byte(`{"name": "Liang", "bills": [{"amount": 1.5, "month": 1}, {"amount": 2, "month": null}]}`), &v)
+	assert.Equal(t, []*SchemaViolation{}, s.Validate(v))
+
+	json.Unmarshal([]byte(`{"name": "", "bills": [{"amount": "1.5", "month": 1.5}, {}, {"amount": 3}]}`), &v)
+	assert.Equal(t, []*SchemaViolation{
+		{"$.name", "required"},
+		{"$.bills", "3 items more than maxItems 2"},
+		{"$.bills[0].amount", "expect number but get string"},
+		{"$.bills[0].month", "expect integer but get number"},
+		{"$.bills[1].amount", "required"},
+		{"$.name", `"" does not match ^[^0-9]+$`},
+	}, s.Validate(v))
+
+	assert.Equal(t, []*SchemaViolation{
+		{"$.bills", "0 items less than minItems 1"},
+		{"$.name", `"abc1" does not match ^[^0-9]+$`},
+	}, s.Validate(map[string]interface{}{"name": "abc1", "bills": []string{}}))
+
+	minItems := 1
+	assert.Equal(t, []*SchemaViolation{
+		{"$.bills", "null less than minItems 1"},
+	}, (&Schema{Properties: map[string]*Schema{"bills": {Type: "array", MinItems: &minItems}}}).Validate(map[string]interface{}{}))
+
+	assert.Error(t, (&Schema{Type: "objekt"}).Check())
+	assert.Error(t, (&Schema{Items: &Schema{Pattern: "(["}}).Check())
+}
+
+func TestSchemaOutput(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `<html><body><div id="a">ok</div></body></html>`)
+	}))
+	defer ts.Close()
+
+	msg := runErrorTmpl(fmt.Sprintf(schemaTmpl, `"a"`, ts.URL))
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, msg.Status)
+	assert.Equal(t, `{"a":"ok"}`, msg.Data)
+
+	msg = runErrorTmpl(fmt.Sprintf(schemaTmpl, `"a", "b"`, ts.URL))
+	assert.Equal(t, cmd.INVALID_OUTPUT, msg.Status)
+	assert.Equal(t, `[{"path":"$.b","message":"required"}]`, msg.Data)
+
+	prev := config.Get()
+	defer config.Set(prev)
+	dir, _ := ioutil.TempDir("", "schema")
+	defer os.RemoveAll(dir)
+	config.Set(&config.Config{OutputRoot: dir + "/"})
+	tmpl := strings.Replace(fmt.Sprintf(schemaTmpl, `"a", "b"`, ts.URL), `"disable_output_folder": true`, `"disable_output_folder": false`, 1)
+	msg = runErrorTmpl(tmpl)
+	assert.Equal(t, cmd.INVALID_OUTPUT, msg.Status)
+	files, _ := filepath.Glob(dir + "/mock/*/*/*/*/ExtractorInfo.json")
+	assert.Equal(t, 1, len(files))
+	tars, _ := filepath.Glob(dir + "/mock/*/*/*/*.tar")
+	assert.Equal(t, 1, len(tars))
+}
+
+var schemaTmpl = `
+{
+    "disable_out_pub_key": true,
+    "disable_output_folder": true,
+    "schema": {"required": [%s]},
+    "steps": [{"page": "%s", "doc_type": "html", "extractor": {"a": "#a"}}]
+}
+`
@@ -0,0 +1,266 @@
+package task
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"github.com/xlvector/dlog"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	"github.com/xlvector/higgs/util"
+	"io"
+	"math"
+	"time"
+)
+
+// credentialKeys are the args and context keys left out of snapshots saved
+// without a session key.
+var credentialKeys = []string{cmd.PARAM_PASSWORD, cmd.PARAM_PASSWORD2, cmd.PARAM_VERIFY_CODE}
+
+// Session is the snapshot of a TaskCmd waiting for input. With the session key
+// of the config, Data, Args, Cookies and PrivateKey are only saved encrypted
+// in Secret. Without it, the credentials and the private key are not saved.
+// CreateTime keeps the max lifetime of the command across restores.
+type Session struct {
+	Id               string                 `json:"id"`
+	Tmpl             string                 `json:"tmpl"`
+	UserId           string                 `json:"user_id"`
+	UserName         string                 `json:"user_name"`
+	Url              string                 `json:"url"`
+	Task             *Task                  `json:"task"`
+	Step             int                    `json:"step"`
+	Retry            map[string]int         `json:"retry"`
+	Data             map[string]interface{} `json:"data"`
+	Args             map[string]string      `json:"args"`
+	Cookies          []byte                 `json:"cookies"`
+	PrivateKey       []byte                 `json:"private_key"`
+	LastPageUrl      string                 `json:"last_page_url"`
+	ExtractorResults map[string]interface{} `json:"extractor_results"`
+	CallbackUrl      string                 `json:"callback_url"`
+	UserAgent        string                 `json:"user_agent"`
+	Secret           []byte                 `json:"secret,omitempty"`
+	CreateTime       int64                  `json:"create_time"`
+	UpdateTime       int64                  `json:"update_time"`
+}
+
+func sessionCipher() (cipher.AEAD, error) {
+	key := config.Get().Session.Key
+	if len(key) == 0 {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSession(aead cipher.AEAD, b []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, b, nil), nil
+}
+
+func openSession(aead cipher.AEAD, b []byte) ([]byte, error) {
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("session secret too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, b[:n], b[n:], nil)
+}
+
+func withoutCredentials(data map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		ret[k] = v
+	}
+	for _, k := range credentialKeys {
+		delete(ret, k)
+	}
+	return ret
+}
+
+func withoutCredentialArgs(args map[string]string) map[string]string {
+	ret := make(map[string]string, len(args))
+	for k, v := range args {
+		ret[k] = v
+	}
+	for _, k := range credentialKeys {
+		delete(ret, k)
+	}
+	return ret
+}
+
+func (s *TaskCmdFactory) SetSessionStore(store cmd.SessionStore) {
+	s.store = store
+}
+
+func (s *TaskCmdFactory) RestoreCommand(data []byte) cmd.Command {
+	var ss Session
+	err := json.Unmarshal(data, &ss)
+	if err != nil || ss.Task == nil {
+		dlog.Warn("fail to unmarshal session: %v", err)
+		return nil
+	}
+	ret, err := s.newTaskCmd(ss.Id, ss.Tmpl, ss.UserId, ss.Task, nil)
+	if err != nil {
+		dlog.Warn("fail to create session %s: %v", ss.Id, err)
+		return nil
+	}
+	err = ret.restore(&ss)
+	if err != nil {
+		dlog.Warn("fail to restore session %s: %v", ss.Id, err)
+		return nil
+	}
+	if time.Since(ret.createTime) > ret.maxLifetime() {
+		dlog.Warn("session %s is beyond its max lifetime", ss.Id)
+		return nil
+	}
+	dlog.Info("%s restored at step %d", ret.GetId(), ret.step)
+	go ret.run()
+	return ret
+}
+
+func (p *TaskCmd) snapshot() ([]byte, error) {
+	cookies := &bytes.Buffer{}
+	err := p.downloader.Jar.WriteTo(cookies)
+	if err != nil {
+		return nil, err
+	}
+	ss := &Session{
+		Id:               p.id,
+		Tmpl:             p.tmpl,
+		UserId:           p.userId,
+		UserName:         p.userName,
+		Url:              p.url,
+		Task:             p.task,
+		Step:             p.step,
+		Retry:            p.retry,
+		LastPageUrl:      p.downloader.LastPageUrl,
+		ExtractorResults: p.downloader.ExtractorResults,
+		UserAgent:        p.downloader.UserAgent,
+		CreateTime:       p.createTime.UnixNano(),
+		UpdateTime:       time.Now().Unix(),
+	}
+	if p.callback != nil {
+		ss.CallbackUrl = p.callback.Url
+	}
+	aead, err := sessionCipher()
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil {
+		ss.Data = withoutCredentials(p.downloader.Context.Data)
+		ss.Args = withoutCredentialArgs(p.args)
+		ss.Cookies = cookies.Bytes()
+		return json.Marshal(ss)
+	}
+	secret := &Session{
+		Data:    p.downloader.Context.Data,
+		Args:    p.args,
+		Cookies: cookies.Bytes(),
+	}
+	if p.privateKey != nil {
+		secret.PrivateKey = util.PrivateKeyString(p.privateKey)
+	}
+	b, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	ss.Secret, err = sealSession(aead, b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ss)
+}
+
+func (p *TaskCmd) restore(ss *Session) error {
+	if len(ss.Secret) > 0 {
+		aead, err := sessionCipher()
+		if err != nil {
+			return err
+		}
+		if aead == nil {
+			return errors.New("no session key to decrypt the session")
+		}
+		b, err := openSession(aead, ss.Secret)
+		if err != nil {
+			return err
+		}
+		var secret Session
+		if err = json.Unmarshal(b, &secret); err != nil {
+			return err
+		}
+		ss.Data, ss.Args, ss.Cookies, ss.PrivateKey = secret.Data, secret.Args, secret.Cookies, secret.PrivateKey
+	}
+	if ss.CreateTime > 0 {
+		p.createTime = time.Unix(0, ss.CreateTime)
+	}
+	if len(ss.PrivateKey) > 0 {
+		pk, err := util.ParsePrivateKey(ss.PrivateKey)
+		if err != nil {
+			return err
+		}
+		p.privateKey = pk
+	}
+	err := p.downloader.Jar.ReadFrom(bytes.NewReader(ss.Cookies))
+	if err != nil {
+		return err
+	}
+	p.callback = config.GetCallback(p.tmpl, ss.CallbackUrl)
+	p.userName = ss.UserName
+	p.url = ss.Url
+	p.step = ss.Step
+	p.retry = ss.Retry
+	if p.retry == nil {
+		p.retry = make(map[string]int)
+	}
+	if ss.Args != nil {
+		p.args = ss.Args
+	}
+	for k, v := range ss.Data {
+		p.downloader.Context.Set(k, restoreNumber(v))
+	}
+	p.downloader.LastPageUrl = ss.LastPageUrl
+	if len(ss.UserAgent) > 0 {
+		p.downloader.UserAgent = ss.UserAgent
+	}
+	if ss.ExtractorResults != nil {
+		p.downloader.ExtractorResults = ss.ExtractorResults
+	}
+	p.resumed = true
+	return nil
+}
+
+// restoreNumber turns whole numbers back to int after the json round trip,
+// since context funcs like add only work on int.
+func restoreNumber(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < math.MaxInt32 {
+		return int(f)
+	}
+	return v
+}
+
+// saveSession is called by run before it blocks for input, which is the only
+// time the state of the command is both consistent and worth keeping.
+func (p *TaskCmd) saveSession() {
+	if p.store == nil || p.casperJS != nil || p.Finished() || p.history.Closed() {
+		return
+	}
+	b, err := p.snapshot()
+	if err != nil {
+		dlog.Warn("%s snapshot fail: %v", p.GetId(), err)
+		return
+	}
+	err = p.store.Save(p.GetId(), b)
+	if err != nil {
+		dlog.Warn("%s save session fail: %v", p.GetId(), err)
+	}
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	hproxy "github.com/xlvector/higgs/proxy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreCommand(t *testing.T) {
+	prev := config.Get()
+	defer config.Set(prev)
+	for _, key := range []string{"", "secret"} {
+		config.Set(&config.Config{Session: config.Session{Key: key}})
+		testRestoreCommand(t, key)
+	}
+}
+
+func testRestoreCommand(t *testing.T, key string) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(rw, &http.Cookie{Name: "session", Value: "abc"})
+			return
+		}
+		c, _ := r.Cookie("session")
+		fmt.Fprintf(rw, "<html><body><div id=\"code\">%s</div><div id=\"cookie\">%s</div></body></html>", r.URL.Query().Get("code"), c.Value)
+	}))
+	defer ts.Close()
+	dir, _ := ioutil.TempDir("", "session")
+	defer os.RemoveAll(dir)
+	store, _ := cmd.NewFileSessionStore(dir, time.Minute)
+
+	var task Task
+	json.Unmarshal([]byte(fmt.Sprintf(sessionTmpl, ts.URL, ts.URL)), &task)
+	factory := NewTaskCmdFactory(nil, hproxy.NewProxyManager(""))
+	factory.SetSessionStore(store)
+
+	c := factory.CreateCommandWithTask(url.Values{"tmpl": {"mock"}}, &task)
+	c.SetInputArgs(map[string]string{"id": c.GetId(), "password": "pass1234"})
+	msg := c.GetMessage()
+	assert.Equal(t, cmd.NEED_PARAM, msg.Status)
+	assert.Equal(t, "randcode", msg.NeedParam)
+	time.Sleep(100 * time.Millisecond)
+	c.Close()
+
+	data, err := store.Load(c.GetId())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	info, _ := os.Stat(dir + "/" + url.QueryEscape(c.GetId()) + ".json")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.False(t, strings.Contains(string(data), "pass1234"))
+	assert.Equal(t, len(key) > 0, !strings.Contains(string(data), "session=abc"))
+
+	var ss Session
+	json.Unmarshal(data, &ss)
+	ss.CreateTime = time.Now().Add(-time.Hour).UnixNano()
+	old, _ := json.Marshal(&ss)
+	assert.Nil(t, factory.RestoreCommand(old))
+
+	c2 := factory.RestoreCommand(data)
+	c2.SetInputArgs(map[string]string{"id": c.GetId(), "randcode": "1234"})
+	msg = c2.GetMessage()
+	assert.Equal(t, cmd.FINISH_FETCH_DATA, msg.Status)
+	assert.Equal(t, `{"code":"1234","cookie":"abc","n":"2"}`, msg.Data)
+}
+
+var sessionTmpl = `
+{
+    "disable_out_pub_key": true,
+    "disable_output_folder": true,
+    "steps": [
+        {"page": "%s/login", "context_opers": ["{{add \"n\" 1}}"]},
+        {"need_param": "randcode"},
+        {
+            "page": "%s/data?code={{.randcode}}",
+            "doc_type": "html",
+            "context_opers": ["{{add \"n\" 1}}"],
+            "extractor": {"code": "#code", "cookie": "#cookie", "n": "c:{{.n}}"}
+        }
+    ]
+}
+`
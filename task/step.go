@@ -1,9 +1,11 @@
 package task
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/SKatiyar/qr"
 	"github.com/xlvector/dama2"
 	"github.com/xlvector/dlog"
@@ -12,8 +14,13 @@ import (
 	"github.com/xlvector/higgs/context"
 	"github.com/xlvector/higgs/extractor"
 	"github.com/xlvector/higgs/util"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -45,6 +52,21 @@ type UploadImage struct {
 	Base64Src  string `json:"base64_src"`
 }
 
+// MultipartFile is a file part of a multipart form, Content is a template
+// whose value is decoded from base64 when Base64 is set.
+type MultipartFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	Content     string `json:"content"`
+	Base64      bool   `json:"base64"`
+	ContentType string `json:"content_type"`
+}
+
+type Multipart struct {
+	Fields map[string]string `json:"fields"`
+	Files  []*MultipartFile  `json:"files"`
+}
+
 func (p *UploadImage) Filename() string {
 	return p.ContextKey + "." + p.Format
 }
@@ -54,6 +76,9 @@ type Step struct {
 	Tag             string                 `json:"tag"`
 	Retry           *Retry                 `json:"retry"`
 	CookieJar       string                 `json:"cookiejar"`
+	CookieFormat    string                 `json:"cookie_format"`
+	CookieFile      string                 `json:"cookie_file"`
+	ExportCookies   *CookieExport          `json:"export_cookies"`
 	Condition       string                 `json:"condition"`
 	NeedParam       string                 `json:"need_param"`
 	Page            string                 `json:"page"`
@@ -62,6 +87,9 @@ type Step struct {
 	Params          map[string]string      `json:"params"`
 	Actions         []*Action              `json:"actions"`
 	JsonPostBody    interface{}            `json:"json_post_body"`
+	Body            string                 `json:"body"`
+	ContentType     string                 `json:"content_type"`
+	Multipart       *Multipart             `json:"multipart"`
 	UploadImage     *UploadImage           `json:"upload_image"`
 	Captcha         *Captcha               `json:"captcha"`
 	QRcodeImage     *QRCodeImage           `json:"qrcode_image"`
@@ -71,6 +99,11 @@ type Step struct {
 	ExtractorSource string                 `json:"extractor_source"`
 	Extractor       map[string]interface{} `json:"extractor"`
 	Sleep           int                    `json:"sleep"`
+	OnError         map[string]string      `json:"on_error"`
+	HttpRetry       *HttpRetry             `json:"http_retry"`
+	Download        *FileDownload          `json:"download"`
+	Charset         string                 `json:"charset"`
+	Redirect        *Redirect              `json:"redirect"`
 	Message         map[string]string
 }
 
@@ -92,9 +125,12 @@ func (s *Step) addContextOutputs(c *context.Context) {
 	}
 }
 
-func (s *Step) extract(body []byte, d *Downloader) {
+func (s *Step) extract(body []byte, d *Downloader) error {
 	if s.Extractor == nil || len(s.Extractor) == 0 {
-		return
+		return nil
+	}
+	if !extractor.SupportDocType(s.DocType) {
+		return newStepError(ERROR_TEMPLATE, errors.New("unsupported doc type "+s.DocType))
 	}
 	if len(s.ExtractorSource) > 0 {
 		body = []byte(d.Context.Parse(s.ExtractorSource))
@@ -102,9 +138,52 @@ func (s *Step) extract(body []byte, d *Downloader) {
 	ret, err := extractor.Extract(body, s.Extractor, s.DocType, d.Context)
 	if err != nil {
 		dlog.Warn("extract error of %v: %v", s.Extractor, err)
-		return
+		return newStepError(ERROR_PARSE, err)
 	}
 	d.AddExtractorResult(ret)
+	if isEmptyResult(ret) {
+		return newStepError(ERROR_EXTRACT, errors.New("extractor get nothing"))
+	}
+	return nil
+}
+
+// extractPage extracts body, or the downloaded file of a download step, like
+// a csv or xlsx statement, which is never kept in memory as the body.
+func (s *Step) extractPage(body []byte, d *Downloader) error {
+	if s.Download == nil || d.LastFile == nil || len(s.Extractor) == 0 {
+		return s.extract(body, d)
+	}
+	b, err := ioutil.ReadFile(d.LastFile.Path)
+	if err != nil {
+		return newStepError(ERROR_PARSE, err)
+	}
+	return s.extract(b, d)
+}
+
+// isEmptyResult tells whether every field of an extractor result is empty,
+// which usually means the selectors do not match the page any more.
+func isEmptyResult(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return len(val) == 0
+	case []interface{}:
+		for _, e := range val {
+			if !isEmptyResult(e) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, e := range val {
+			if !isEmptyResult(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 func (s *Step) getHeader(c *context.Context) map[string]string {
@@ -120,18 +199,121 @@ func (s *Step) getRawPostData() []byte {
 	return b
 }
 
+var supportedMethods = map[string]bool{
+	"":         true,
+	"GET":      true,
+	"POST":     true,
+	"POSTJSON": true,
+	"PUT":      true,
+	"PATCH":    true,
+	"DELETE":   true,
+	"HEAD":     true,
+	"OPTIONS":  true,
+}
+
+func (s *Step) getMultipartBody(c *context.Context) ([]byte, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range s.Multipart.Fields {
+		writer.WriteField(c.Parse(k), c.Parse(v))
+	}
+	for _, f := range s.Multipart.Files {
+		content := []byte(c.Parse(f.Content))
+		if f.Base64 {
+			b, err := base64.StdEncoding.DecodeString(string(content))
+			if err != nil {
+				return nil, "", err
+			}
+			content = b
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, c.Parse(f.Field), c.Parse(f.Filename)))
+		ct := f.ContentType
+		if len(ct) == 0 {
+			ct = "application/octet-stream"
+		}
+		h.Set("Content-Type", ct)
+		part, err := writer.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		part.Write(content)
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// getBody returns the body of the step, which is the multipart form, the
+// json_post_body, the body template or the params as a form, in this order.
+func (s *Step) getBody(c *context.Context) (io.Reader, string, error) {
+	if s.Multipart != nil {
+		b, ct, err := s.getMultipartBody(c)
+		if err != nil {
+			return nil, "", newStepError(ERROR_TEMPLATE, err)
+		}
+		return bytes.NewReader(b), ct, nil
+	}
+	if s.JsonPostBody != nil {
+		ct := s.ContentType
+		if len(ct) == 0 {
+			ct = "application/json; charset=UTF-8"
+		}
+		return bytes.NewReader(s.getRawPostData()), ct, nil
+	}
+	if len(s.Body) > 0 {
+		ct := s.ContentType
+		if len(ct) == 0 {
+			ct = "text/plain; charset=UTF-8"
+		}
+		return strings.NewReader(c.Parse(s.Body)), ct, nil
+	}
+	if len(s.Params) > 0 {
+		params := url.Values{}
+		for k, v := range s.getParams(c) {
+			params.Set(k, v)
+		}
+		return strings.NewReader(params.Encode()), "application/x-www-form-urlencoded; charset=UTF-8", nil
+	}
+	return nil, "", nil
+}
+
 func (s *Step) download(d *Downloader) ([]byte, error) {
 	page := s.getPageUrls(d.Context)
 	dlog.Info("download %s", page)
 	d.UpdateCookieToContext(page)
+	d.Retry = s.HttpRetry
+	d.Charset = s.Charset
+	d.Redirect = s.Redirect
+	d.LastFile = nil
+	if s.Download != nil {
+		d.Stream = &FileDownload{
+			Filename: d.Context.Parse(s.Download.Filename),
+			MaxSize:  s.Download.MaxSize,
+		}
+	}
+	defer func() {
+		d.Retry = nil
+		d.Stream = nil
+		d.Charset = ""
+		d.Redirect = nil
+	}()
 	if len(s.Method) == 0 || s.Method == "GET" {
 		return d.Get(page, s.getHeader(d.Context))
-	} else if s.Method == "POST" {
+	} else if s.Method == "POST" && s.Multipart == nil && len(s.Body) == 0 {
 		return d.Post(page, s.getParams(d.Context), s.getHeader(d.Context))
 	} else if s.Method == "POSTJSON" {
 		return d.PostRaw(page, s.getRawPostData(), s.getHeader(d.Context))
+	} else if supportedMethods[s.Method] {
+		body, ct, err := s.getBody(d.Context)
+		if err != nil {
+			return nil, err
+		}
+		return d.Request(s.Method, page, body, ct, s.getHeader(d.Context))
 	}
-	return nil, errors.New("unsupported method: " + s.Method)
+	return nil, newStepError(ERROR_TEMPLATE, errors.New("unsupported method: "+s.Method))
 }
 
 func (s *Step) passCondition(c *context.Context) bool {
@@ -166,20 +348,34 @@ func (s *Step) procUploadImage(body []byte, d *Downloader) error {
 	imgLink, err := util.UploadBody(b, d.OutputFolder+"/"+s.UploadImage.Filename(), CAPTCHA_BUCKET)
 	if err != nil {
 		dlog.Warn("upload image fail: %v", err)
-		return err
+		return newStepError(ERROR_UPLOAD, err)
 	}
 	dlog.Info("upload image to %s", imgLink)
 	d.Context.Set(s.UploadImage.ContextKey, imgLink)
 	return nil
 }
 
+// Do runs the step. A download error stops the step, other errors are
+// logged and the step goes on, the first of them is returned unless a later
+// one has a fatal policy in on_error.
 func (s *Step) Do(d *Downloader, dm *dama2.Dama2Client, cas *casperjs.CasperJS) error {
 	if !s.passCondition(d.Context) {
 		return nil
 	}
 
-	if len(s.CookieJar) > 0 {
-		d.SetCookie(d.Context.Parse(s.CookieJar))
+	var ret error
+	fatal := func(err error) bool {
+		return err != nil && s.errorPolicy(ErrorClass(err)) != POLICY_CONTINUE
+	}
+	keep := func(err error) {
+		if err != nil && (ret == nil || !fatal(ret) && fatal(err)) {
+			ret = err
+		}
+	}
+
+	if err := s.importCookies(d); err != nil {
+		dlog.Warn("import cookies error: %v", err)
+		keep(err)
 	}
 
 	body := []byte{}
@@ -189,15 +385,27 @@ func (s *Step) Do(d *Downloader, dm *dama2.Dama2Client, cas *casperjs.CasperJS)
 		if err != nil {
 			return err
 		}
+		if d.LastPageStatus/100 == 4 || d.LastPageStatus/100 == 5 {
+			keep(newStepError(ERROR_HTTP_STATUS, fmt.Errorf("status %d of %s", d.LastPageStatus, d.LastPageUrl)))
+		}
 	}
 
 	//output file name should calculated before context operations
 	out := s.GetOutputFilename(d.Context)
 	d.Context.Set("_body", string(body))
 	s.addContextOutputs(d.Context)
-	s.extract(body, d)
+	if s.Download != nil && d.LastFile != nil {
+		key := s.Download.key()
+		d.Context.Set(key, d.LastFile.Path)
+		d.Context.Set(key+"_size", d.LastFile.Size)
+		d.Context.Set(key+"_sha256", d.LastFile.Sha256)
+	}
+	// an error page would only put garbage into the extractor results
+	if !fatal(ret) {
+		keep(s.extractPage(body, d))
+	}
 
-	if len(out) > 0 {
+	if len(out) > 0 && s.Download == nil {
 		dlog.Info("write file %s to %s", out, d.OutputFolder+"/"+out)
 		err := ioutil.WriteFile(d.OutputFolder+"/"+out, body, 0655)
 		if err != nil {
@@ -206,18 +414,20 @@ func (s *Step) Do(d *Downloader, dm *dama2.Dama2Client, cas *casperjs.CasperJS)
 	}
 
 	if s.UploadImage != nil {
-		s.procUploadImage(body, d)
+		keep(s.procUploadImage(body, d))
 	}
 
 	if s.QRcodeImage != nil {
 		qc, qerr := qr.Encode(d.Context.Parse(s.QRcodeImage.Src), qr.M)
 		if qerr != nil {
 			dlog.Warn("Encode Qrcode Err:%s", qerr.Error())
+			keep(newStepError(ERROR_TEMPLATE, qerr))
 		} else {
 			png := qc.PNG()
 			uploadUrl, err := util.UploadBody(png, d.OutputFolder+"/qrcode.png", CAPTCHA_BUCKET)
 			if err != nil {
 				dlog.Warn("upload image err:%s", err.Error())
+				keep(newStepError(ERROR_UPLOAD, err))
 			}
 			d.Context.Set(s.QRcodeImage.ContextKey, uploadUrl)
 		}
@@ -225,15 +435,21 @@ func (s *Step) Do(d *Downloader, dm *dama2.Dama2Client, cas *casperjs.CasperJS)
 
 	if s.Captcha != nil && dm != nil {
 		ct, _ := strconv.Atoi(s.Captcha.CodeType)
-		cret, err := dm.Captcha(body, s.Captcha.ImgFormat, ct, config.Instance.Captcha.AppId, config.Instance.Captcha.Username, config.Instance.Captcha.Password)
+		conf := config.Get().Captcha
+		cret, err := dm.Captcha(body, s.Captcha.ImgFormat, ct, conf.AppId, conf.Username, conf.Password)
 		if err != nil {
 			dlog.Warn("decode captcha error : %v", err)
+			keep(newStepError(ERROR_CAPTCHA, err))
 		}
 		d.Context.Set(s.Captcha.ContextKey, cret)
 	}
 
+	if s.ExportCookies != nil {
+		keep(s.exportCookies(d))
+	}
+
 	if s.Sleep > 0 {
 		time.Sleep(time.Duration(s.Sleep) * time.Second)
 	}
-	return nil
+	return ret
 }
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/xlvector/higgs/jsonpath"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -27,7 +30,7 @@ func TestStep(t *testing.T) {
 	c := []byte(stepConfig)
 	var step Step
 	json.Unmarshal([]byte(c), &step)
-	d := NewDownloader(nil, nil, "./", nil)
+	d, _ := NewDownloader(nil, nil, "./", nil, nil)
 	d.Context.Set("tmpl", "mock")
 	d.Context.Set("query", "001")
 	err := step.Do(d, nil, nil)
@@ -81,3 +84,111 @@ var stepConfig = `
     }
 }
 `
+
+func TestStepMethods(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		body := ""
+		if ct == "multipart/form-data" {
+			r.ParseMultipartForm(1 << 20)
+			f, h, _ := r.FormFile("file")
+			b, _ := ioutil.ReadAll(f)
+			body = r.FormValue("name") + ":" + h.Filename + ":" + string(b)
+		} else {
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+		}
+		fmt.Fprintf(rw, "%s %s %s", r.Method, ct, body)
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		step   string
+		expect string
+	}{
+		{`{"page": "%s", "method": "PUT", "json_post_body": {"a": 1}}`, `PUT application/json {"a":1}`},
+		{`{"page": "%s", "method": "PATCH", "body": "<a>{{.v}}</a>", "content_type": "text/xml"}`, `PATCH text/xml <a>x</a>`},
+		{`{"page": "%s", "method": "DELETE", "params": {"v": "{{.v}}"}}`, `DELETE application/x-www-form-urlencoded v=x`},
+		{`{"page": "%s", "method": "OPTIONS"}`, `OPTIONS  `},
+		{`{"page": "%s", "method": "POST", "multipart": {"fields": {"name": "{{.v}}"}, "files": [{"field": "file", "filename": "a.txt", "content": "aGVsbG8=", "base64": true}]}}`, `POST multipart/form-data x:a.txt:hello`},
+	}
+	for _, c := range cases {
+		var step Step
+		json.Unmarshal([]byte(fmt.Sprintf(c.step, ts.URL)), &step)
+		d, _ := NewDownloader(nil, nil, "", nil, nil)
+		d.Context.Set("v", "x")
+		err := step.Do(d, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, string(d.LastPage))
+	}
+
+	var step Step
+	json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "method": "HEAD"}`, ts.URL)), &step)
+	d, _ := NewDownloader(nil, nil, "", nil, nil)
+	assert.Nil(t, step.Do(d, nil, nil))
+	assert.Equal(t, 200, d.LastPageStatus)
+	assert.Equal(t, 0, len(d.LastPage))
+}
+
+func TestStepResponseContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Auth-Token", "token")
+		rw.Header().Add("X-Trace", "a")
+		rw.Header().Add("X-Trace", "b")
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		fmt.Fprint(rw, `{"ok":true}`)
+	}))
+	defer ts.Close()
+
+	var step Step
+	json.Unmarshal([]byte(fmt.Sprintf(`{
+        "page": "%s/login",
+        "method": "POST",
+        "params": {"a": "b"},
+        "actions": [{"condition": "{{if eq ._status 201}}{{eq (index ._headers \"X-Auth-Token\") \"token\"}}{{end}}", "goto": "next"}]
+    }`, ts.URL)), &step)
+	d, _ := NewDownloader(nil, nil, "", nil, nil)
+	assert.Nil(t, step.Do(d, nil, nil))
+	assert.NotNil(t, step.GetAction(d.Context))
+
+	v, _ := d.Context.Get("_headers")
+	assert.Equal(t, "a, b", v.(map[string]interface{})["X-Trace"])
+	v, _ = d.Context.Get("_url")
+	assert.Equal(t, ts.URL+"/login", v)
+	v, _ = d.Context.Get("_content_type")
+	assert.Equal(t, "application/json", v)
+	v, _ = d.Context.Get("_size")
+	assert.Equal(t, 11, v)
+	_, ok := d.Context.Get("_elapsed")
+	assert.True(t, ok)
+
+	ts.Close()
+	d.Get(ts.URL+"/down", nil)
+	for _, k := range []string{"_status", "_headers", "_url"} {
+		_, ok = d.Context.Get(k)
+		assert.False(t, ok, k)
+	}
+}
+
+func TestStepExtractAfterContinue(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(rw, `<html><body><div id="a">error page</div></body></html>`)
+	}))
+	defer ts.Close()
+
+	for _, policy := range []string{"continue", "fail"} {
+		var step Step
+		json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "doc_type": "html", "extractor": {"a": "#a"},
+			"on_error": {"http_status": "%s"}}`, ts.URL, policy)), &step)
+		d, _ := NewDownloader(nil, nil, "", nil, nil)
+		err := step.Do(d, nil, nil)
+		assert.Equal(t, ERROR_HTTP_STATUS, ErrorClass(err))
+		if policy == "continue" {
+			assert.Equal(t, "error page", d.ExtractorResults["a"])
+		} else {
+			assert.Nil(t, d.ExtractorResults["a"])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package task
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DEFAULT_MAX_DOWNLOAD_SIZE = 100 << 20
+	DEFAULT_DOWNLOAD_KEY      = "_file"
+)
+
+// FileDownload is the download of a step. The body is written to Filename
+// in the output folder instead of the context, and the download fails when
+// it is larger than MaxSize bytes. The path, size and sha256 of the file are
+// set to ContextKey, ContextKey_size and ContextKey_sha256.
+type FileDownload struct {
+	Filename   string `json:"filename"`
+	MaxSize    int64  `json:"max_size"`
+	ContextKey string `json:"context_key"`
+}
+
+type DownloadedFile struct {
+	Path        string
+	Size        int64
+	Sha256      string
+	ContentType string
+}
+
+func (p *FileDownload) key() string {
+	if len(p.ContextKey) == 0 {
+		return DEFAULT_DOWNLOAD_KEY
+	}
+	return p.ContextKey
+}
+
+type decodeReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *decodeReader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// decodeBody returns the body of resp decoded by its Content-Encoding, the
+// caller still has to close resp.Body.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// deflate should be zlib wrapped, but many servers send it raw
+		br := bufio.NewReader(resp.Body)
+		head, err := br.Peek(2)
+		if err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return &decodeReader{Reader: brotli.NewReader(resp.Body)}, nil
+	}
+	return ioutil.NopCloser(resp.Body), nil
+}
+
+// inFolder tells whether path is a file under folder once both are cleaned,
+// since the filename of a download may come from the context.
+func inFolder(folder, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(folder), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// savePage streams the body of resp to the file of Stream.
+func (s *Downloader) savePage(resp *http.Response) error {
+	defer resp.Body.Close()
+	if len(s.OutputFolder) == 0 {
+		return errors.New("no output folder to download " + s.Stream.Filename)
+	}
+	reader, err := decodeBody(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	max := s.Stream.MaxSize
+	if max <= 0 {
+		max = DEFAULT_MAX_DOWNLOAD_SIZE
+	}
+	path := s.OutputFolder + "/" + s.Stream.Filename
+	if !inFolder(s.OutputFolder, path) {
+		return errors.New("download " + s.Stream.Filename + " is out of the output folder")
+	}
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(reader, max+1))
+	f.Close()
+	if err == nil && n > max {
+		err = fmt.Errorf("download %s is larger than %d bytes", s.Stream.Filename, max)
+	}
+	if err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+	err = os.Rename(path+".part", path)
+	if err != nil {
+		return err
+	}
+
+	s.LastPageUrl = resp.Request.URL.String()
+	s.LastPage = nil
+	s.LastPageContentType, _ = decodeCharset(resp.Header.Get("Content-Type"))
+	s.LastFile = &DownloadedFile{
+		Path:        path,
+		Size:        n,
+		Sha256:      hex.EncodeToString(h.Sum(nil)),
+		ContentType: s.LastPageContentType,
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package task
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func encodeBody(encoding string, body []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(buf)
+	default:
+		return body
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestStreamDownload(t *testing.T) {
+	body := []byte(strings.Repeat("statement,", 1000))
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("e")
+		if encoding == "raw-deflate" {
+			rw.Header().Set("Content-Encoding", "deflate")
+		} else if len(encoding) > 0 {
+			rw.Header().Set("Content-Encoding", encoding)
+		}
+		rw.Header().Set("Content-Type", "text/csv")
+		rw.Write(encodeBody(encoding, body))
+	}))
+	defer ts.Close()
+
+	for _, e := range []string{"", "gzip", "deflate", "raw-deflate", "br"} {
+		d, _ := NewDownloader(nil, nil, "", nil, nil)
+		b, err := d.Get(ts.URL+"?e="+e, map[string]string{"Accept-Encoding": e})
+		assert.Nil(t, err)
+		assert.Equal(t, body, b, e)
+	}
+
+	dir, _ := ioutil.TempDir("", "stream")
+	defer os.RemoveAll(dir)
+	sum := sha256.Sum256(body)
+	for _, e := range []string{"", "br"} {
+		var step Step
+		json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s?e=%s", "download": {"filename": "{{.name}}.csv", "context_key": "csv"}}`, ts.URL, e)), &step)
+		d, _ := NewDownloader(nil, nil, dir, nil, nil)
+		d.Context.Set("name", "statement")
+		assert.Nil(t, step.Do(d, nil, nil))
+		b, _ := ioutil.ReadFile(dir + "/statement.csv")
+		assert.Equal(t, body, b)
+		v, _ := d.Context.Get("csv")
+		assert.Equal(t, dir+"/statement.csv", v)
+		v, _ = d.Context.Get("csv_sha256")
+		assert.Equal(t, hex.EncodeToString(sum[:]), v)
+		v, _ = d.Context.Get("_body")
+		assert.Equal(t, "", v)
+		assert.Equal(t, "text/csv", d.LastPageContentType)
+	}
+
+	var step Step
+	json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "download": {"filename": "table.csv"}, "doc_type": "csv",
+		"extractor": {"_header": 0, "first": "#1"}}`, ts.URL)), &step)
+	d, _ := NewDownloader(nil, nil, dir, nil, nil)
+	assert.Nil(t, step.Do(d, nil, nil))
+	assert.Equal(t, []interface{}{"statement"}, d.ExtractorResults["first"])
+
+	step = Step{}
+	json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "download": {"filename": "big.csv", "max_size": 100}}`, ts.URL)), &step)
+	d, _ = NewDownloader(nil, nil, dir, nil, nil)
+	err := step.Do(d, nil, nil)
+	assert.Equal(t, ERROR_PARSE, ErrorClass(err))
+	_, err = os.Stat(dir + "/big.csv")
+	assert.True(t, os.IsNotExist(err))
+
+	step = Step{}
+	json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "download": {"filename": "../{{.name}}.csv"}}`, ts.URL)), &step)
+	d, _ = NewDownloader(nil, nil, dir+"/out", nil, nil)
+	d.Context.Set("name", "evil")
+	assert.Equal(t, ERROR_PARSE, ErrorClass(step.Do(d, nil, nil)))
+	_, err = os.Stat(dir + "/evil.csv")
+	assert.True(t, os.IsNotExist(err))
+
+	step = Step{}
+	json.Unmarshal([]byte(fmt.Sprintf(`{"page": "%s", "download": {"filename": "recorded.csv"}}`, ts.URL)), &step)
+	d, _ = NewDownloader(nil, nil, dir, &DownloaderConfig{FixtureMode: FIXTURE_RECORD}, nil)
+	assert.Nil(t, step.Do(d, nil, nil))
+	entries, _ := ReadFixtureEntries(dir + "/" + FIXTURE_FILENAME)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, body, entries[0].ResponseBody)
+}
@@ -17,6 +17,11 @@ type Task struct {
 	DisableOutputFolder bool    `json:"disable_output_folder"`
 	CasperjsScript      string  `json:"casperjs_script"`
 	TmplBlockTime	    string  `json:"tmpl_block_time"`
+	SessionTimeout      int     `json:"session_timeout"`
+	SessionMaxLifetime  int     `json:"session_max_lifetime"`
+	ClientProfile       string  `json:"client_profile"`
+	HeaderProfile       string  `json:"header_profile"`
+	Schema              *Schema `json:"schema"`
 }
 
 func NewTask(f string) *Task {
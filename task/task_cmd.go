@@ -2,6 +2,7 @@ package task
 
 import (
 	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"github.com/xlvector/dama2"
 	"github.com/xlvector/dlog"
@@ -12,11 +13,13 @@ import (
 	hproxy "github.com/xlvector/higgs/proxy"
 	"github.com/xlvector/higgs/util"
 	"io/ioutil"
-	_"math/rand"
+	_ "math/rand"
 	"net/url"
 	"os"
+	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,8 +36,7 @@ type TaskCmd struct {
 	userId       string
 	passWord     string
 	path         string
-	url	     string
-	message      chan *cmd.Output
+	url          string
 	input        chan map[string]string
 	args         map[string]string
 	privateKey   *rsa.PrivateKey
@@ -43,14 +45,24 @@ type TaskCmd struct {
 	casperJS     *casperjs.CasperJS
 	dama2Client  *dama2.Dama2Client
 	flumeClient  *flume.Flume
-	finished     bool
-	proxy 	     *hproxy.Proxy
+	finished     int32 // set atomically, the server polls it while run goes on
 	proxyManager *hproxy.ProxyManager
+	step         int
+	retry        map[string]int
+	resumed      bool
+	holding      bool
+	createTime   time.Time
+	store        cmd.SessionStore
+	history      *cmd.History
+	callback     *config.Callback
 }
 
 type TaskCmdFactory struct {
 	taskManager  *TaskManager
 	proxyManager *hproxy.ProxyManager
+	fixtureMode  string
+	fixtureFile  string
+	store        cmd.SessionStore
 }
 
 func NewTaskCmdFactory(tm *TaskManager, pm *hproxy.ProxyManager) *TaskCmdFactory {
@@ -60,6 +72,14 @@ func NewTaskCmdFactory(tm *TaskManager, pm *hproxy.ProxyManager) *TaskCmdFactory
 	}
 }
 
+// SetFixture makes every command created later record its http traffic to,
+// or replay it from, fname. An empty fname means the default fixture file in
+// the output folder of each command.
+func (s *TaskCmdFactory) SetFixture(mode, fname string) {
+	s.fixtureMode = mode
+	s.fixtureFile = fname
+}
+
 func (s *TaskCmdFactory) CreateCommand(params url.Values) cmd.Command {
 	tmpl := params.Get("tmpl")
 	if len(tmpl) == 0 {
@@ -70,6 +90,10 @@ func (s *TaskCmdFactory) CreateCommand(params url.Values) cmd.Command {
 	if task == nil {
 		return nil
 	}
+	return s.CreateCommandWithTask(params, task)
+}
+
+func (s *TaskCmdFactory) CreateCommandWithTask(params url.Values, task *Task) cmd.Command {
 	if !task.DisableOutPubKey {
 		pk, err := util.GenerateRSAKey()
 		if err != nil {
@@ -100,27 +124,42 @@ func (s *TaskCmdFactory) genId(tmpl string) string {
 
 func (s *TaskCmdFactory) genFolderById(id string) string {
 	tks := strings.Split(id, "|")
-	return config.Instance.OutputRoot + strings.Join(tks, "/")
+	return config.Get().OutputRoot + strings.Join(tks, "/")
 }
 
 func (s *TaskCmdFactory) createCommandWithPrivateKey(params url.Values, task *Task, pk *rsa.PrivateKey) cmd.Command {
 	tmpl := params.Get("tmpl")
+	ret, err := s.newTaskCmd(s.genId(tmpl), tmpl, params.Get("userid"), task, pk)
+	if err != nil {
+		dlog.Warn("fail to create command of %s: %v", tmpl, err)
+		return nil
+	}
+	ret.downloader.Context.Set("_id", ret.GetId())
+	ret.downloader.Context.Set("tmpl", tmpl)
+	ret.callback = config.GetCallback(tmpl, params.Get("callback"))
+	go ret.run()
+	return ret
+}
+
+func (s *TaskCmdFactory) newTaskCmd(id, tmpl, userId string, task *Task, pk *rsa.PrivateKey) (*TaskCmd, error) {
+	conf := config.Get()
 	ret := &TaskCmd{
-		id:          s.genId(tmpl),
+		id:          id,
 		tmpl:        tmpl,
 		userName:    "",
-		userId:      params.Get("userid"),
+		userId:      userId,
 		passWord:    "",
-		message:     make(chan *cmd.Output, 5),
 		input:       make(chan map[string]string, 5),
 		args:        make(map[string]string),
 		task:        task,
-		dama2Client: dama2.NewDama2Client(config.Instance.Captcha.Key),
-		finished:    false,
+		dama2Client: dama2.NewDama2Client(conf.Captcha.Key),
+		store:       s.store,
+		history:     cmd.NewHistory(),
+		createTime:  time.Now(),
 	}
 
-	if config.Instance.HasFlume() {
-		ret.flumeClient = flume.NewFlume(config.Instance.Flume.Host, config.Instance.Flume.Port)
+	if conf.HasFlume() {
+		ret.flumeClient = flume.NewFlume(conf.Flume.Host, conf.Flume.Port)
 	}
 
 	ret.privateKey = pk
@@ -129,36 +168,70 @@ func (s *TaskCmdFactory) createCommandWithPrivateKey(params url.Values, task *Ta
 	if s.proxyManager != nil {
 		p = s.proxyManager.GetTmplProxy(tmpl)
 	}
-	ret.proxy = p
 	ret.proxyManager = s.proxyManager
+	headers := config.GetHeaderProfile(task.HeaderProfile)
+	userAgent := PickUserAgent(headers)
 	if len(task.CasperjsScript) > 0 {
-		ret.casperJS, _ = casperjs.NewCasperJS(ret.path, "./etc/casperjs/"+task.CasperjsScript, "", "")
-		go ret.casperJS.Run()
+		ret.casperJS, _ = casperjs.NewCasperJSWithUserAgent(ret.path, "./etc/casperjs/"+task.CasperjsScript, userAgent)
 	}
 
 	outFolder := ret.path
 	if task.DisableOutputFolder {
 		outFolder = ""
 	}
-	ret.downloader = NewDownloader(ret.casperJS, p, outFolder, &DownloaderConfig{
-		RedisHost:    config.Instance.Redis.Host,
-		RedisTimeout: time.Duration(config.Instance.Redis.Timeout),
-	},   s.proxyManager)
+	fixtureMode := s.fixtureMode
+	if len(fixtureMode) == 0 && conf.RecordFixture && len(outFolder) > 0 {
+		fixtureMode = FIXTURE_RECORD
+	}
+	var err error
+	ret.downloader, err = NewDownloader(ret.casperJS, p, outFolder, &DownloaderConfig{
+		RedisHost:    conf.Redis.Host,
+		RedisTimeout: time.Duration(conf.Redis.Timeout),
+		FixtureMode:  fixtureMode,
+		FixtureFile:  s.fixtureFile,
+		Profile:      config.GetClientProfile(task.ClientProfile),
+		Headers:      headers,
+		UserAgent:    userAgent,
+	}, s.proxyManager)
+	if err != nil {
+		return nil, err
+	}
+	ret.downloader.RateWaiting = ret.rateWaiting
+	if ret.casperJS != nil {
+		go ret.casperJS.Run()
+	}
 	dlog.Println(ret.downloader.Client)
 
 	dlog.Warn("output folder: %s", ret.downloader.OutputFolder)
-	ret.downloader.Context.Set("_id", ret.GetId())
-	ret.downloader.Context.Set("tmpl", tmpl)
-	go ret.run()
-	return ret
+	return ret, nil
 }
 
 func (p *TaskCmd) GetId() string {
 	return p.id
 }
 
+// maxLifetime returns session_max_lifetime of the template in seconds, or the
+// default of the command cache.
+func (p *TaskCmd) maxLifetime() time.Duration {
+	if p.task.SessionMaxLifetime > 0 {
+		return time.Duration(p.task.SessionMaxLifetime) * time.Second
+	}
+	return cmd.DEFAULT_MAX_LIFETIME
+}
+
+// Expiry returns session_timeout of the template in seconds, and what is left
+// of the max lifetime since the command was first created, so that restoring
+// it does not extend its life.
+func (p *TaskCmd) Expiry() (time.Duration, time.Duration) {
+	left := p.maxLifetime() - time.Since(p.createTime)
+	if left <= 0 {
+		left = time.Nanosecond
+	}
+	return time.Duration(p.task.SessionTimeout) * time.Second, left
+}
+
 func (p *TaskCmd) Finished() bool {
-	return p.finished
+	return atomic.LoadInt32(&p.finished) == 1
 }
 
 func (p *TaskCmd) SetInputArgs(input map[string]string) {
@@ -170,7 +243,28 @@ func (p *TaskCmd) SetInputArgs(input map[string]string) {
 }
 
 func (p *TaskCmd) GetMessage() *cmd.Output {
-	return <-p.message
+	msg, _ := p.history.Next(0)
+	return msg
+}
+
+func (p *TaskCmd) GetMessageTimeout(timeout time.Duration) (*cmd.Output, bool) {
+	return p.history.Next(timeout)
+}
+
+func (p *TaskCmd) GetHistory() *cmd.History {
+	return p.history
+}
+
+func (p *TaskCmd) ExportCookies(format string) ([]byte, error) {
+	return p.downloader.ExportCookies(format)
+}
+
+func (p *TaskCmd) ImportCookies(format string, b []byte) error {
+	return p.downloader.ImportCookies(format, b)
+}
+
+func (p *TaskCmd) sendMessage(msg *cmd.Output) {
+	p.history.Add(msg)
 }
 
 func (p *TaskCmd) getUserName() string {
@@ -182,8 +276,23 @@ func (p *TaskCmd) getUserName() string {
 	return p.userName
 }
 
+// readInputArgs gives the session of the template back to Limiter while it
+// waits for the user, and takes one again before going on.
 func (p *TaskCmd) readInputArgs(key string) string {
-	args := <-p.input
+	p.saveSession()
+	held := p.holding
+	if held {
+		p.releaseSession()
+	}
+	args, ok := <-p.input
+	if !ok {
+		dlog.Warn("%s closed while waiting for %s", p.GetId(), key)
+		runtime.Goexit()
+	}
+	if held && !p.acquireSession() {
+		dlog.Warn("%s closed while queued", p.GetId())
+		runtime.Goexit()
+	}
 	for k, v := range args {
 		if k == "username" {
 			p.userName = v
@@ -206,7 +315,7 @@ func (p *TaskCmd) readInputArgs(key string) string {
 		Status:    cmd.NEED_PARAM,
 	}
 	dlog.Warn("%s need param:%s", p.GetId(), key)
-	p.message <- message
+	p.sendMessage(message)
 	return ""
 }
 
@@ -234,12 +343,44 @@ func (p *TaskCmd) Close() bool {
 			dlog.Warn("%s Close Error:%v", p.GetId(), err)
 		}
 	}()
-	close(p.message)
-	p.message = nil
+	p.history.Close()
 	close(p.input)
 	return true
 }
 
+func (p *TaskCmd) acquireSession() bool {
+	if !Limiter.Acquire(p.tmpl, p.queued, p.history.Closed) {
+		return false
+	}
+	p.holding = true
+	return true
+}
+
+func (p *TaskCmd) releaseSession() {
+	if p.holding {
+		p.holding = false
+		Limiter.Release(p.tmpl)
+	}
+}
+
+func (p *TaskCmd) queued() {
+	p.sendMessage(&cmd.Output{
+		Id:     p.GetArgsValue("id"),
+		Status: cmd.QUEUED,
+		Data:   "waiting for a free session of " + p.tmpl,
+	})
+}
+
+// rateWaiting tells the client why a request is late when the rate limit of
+// the template or the host holds it back.
+func (p *TaskCmd) rateWaiting(host string, wait time.Duration) {
+	p.sendMessage(&cmd.Output{
+		Id:     p.GetArgsValue("id"),
+		Status: cmd.QUEUED,
+		Data:   fmt.Sprintf("waiting %.1fs for the rate limit of %s", wait.Seconds(), host),
+	})
+}
+
 func (p *TaskCmd) OutputPublicKey() {
 	if p.task.DisableOutPubKey == false {
 		message := &cmd.Output{
@@ -247,11 +388,10 @@ func (p *TaskCmd) OutputPublicKey() {
 			Status: cmd.OUTPUT_PUBLICKEY,
 			Data:   string(util.PublicKeyString(&p.privateKey.PublicKey)),
 		}
-		p.message <- message
+		p.sendMessage(message)
 	}
 }
 
-
 func (p *TaskCmd) Goto() (map[string]int, map[string]int) {
 	gotoMap := make(map[string]int)
 	retry := make(map[string]int)
@@ -265,6 +405,57 @@ func (p *TaskCmd) Goto() (map[string]int, map[string]int) {
 	return gotoMap, retry
 }
 
+// gotoOnError moves to the step tagged tag after an error of class, at most
+// retry.max_times of step. It returns false when the command is finished.
+func (p *TaskCmd) gotoOnError(step *Step, tag, class string, gotoMap map[string]int) bool {
+	maxRetry := 1
+	if step.Retry != nil {
+		maxRetry = step.Retry.MaxTimes
+	}
+	key := POLICY_GOTO + tag
+	next, ok := gotoMap[tag]
+	if ok && p.retry[key] < maxRetry {
+		p.retry[key]++
+		// gotoMap points to the step before the tag, for the p.step++ of run
+		p.step = next + 1
+		dlog.Info("%s goto step %d with tag %s on %s error", p.GetId(), p.step, tag, class)
+		return true
+	}
+	data := "retry fail on " + class + " error"
+	if !ok {
+		data = "can not find goto tag " + tag
+	}
+	p.finish(&cmd.Output{
+		Status:     cmd.FAIL,
+		Id:         p.GetArgsValue("id"),
+		Data:       data,
+		Url:        p.url,
+		ErrorClass: class,
+	})
+	return false
+}
+
+// checkSchema returns an INVALID_OUTPUT output with the violations in its data
+// instead of msg of FINISH_FETCH_DATA if the extractor results do not match
+// the schema of the task.
+func (p *TaskCmd) checkSchema(msg *cmd.Output) *cmd.Output {
+	if msg.Status != cmd.FINISH_FETCH_DATA || p.task.Schema == nil {
+		return msg
+	}
+	violations := p.task.Schema.Validate(p.downloader.ExtractorResults)
+	if len(violations) == 0 {
+		return msg
+	}
+	b, _ := json.Marshal(violations)
+	dlog.Warn("%s invalid output: %s", p.GetId(), string(b))
+	return &cmd.Output{
+		Status: cmd.INVALID_OUTPUT,
+		Id:     p.GetArgsValue("id"),
+		Data:   string(b),
+		Url:    p.url,
+	}
+}
+
 func (p *TaskCmd) run() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -275,18 +466,24 @@ func (p *TaskCmd) run() {
 	p.downloader.Context.Set(p.tmpl, "exist")
 	dlog.Info("%s begin run cmd:%s", p.GetId(), p.tmpl)
 
-	p.finished = false
-	p.OutputPublicKey()
-
+	atomic.StoreInt32(&p.finished, 0)
+	if !p.acquireSession() {
+		dlog.Warn("%s closed while queued", p.GetId())
+		return
+	}
+	defer p.releaseSession()
 	gotoMap, retry := p.Goto()
+	if !p.resumed {
+		p.OutputPublicKey()
+		p.retry = retry
+	}
 
-	c := 0
 	for {
-		if c >= len(p.task.Steps) {
+		if p.step >= len(p.task.Steps) {
 			break
 		}
 
-		step := p.task.Steps[c]
+		step := p.task.Steps[p.step]
 		//time.Sleep(time.Duration(rand.Int63n(300)) * time.Millisecond)
 
 		if len(step.NeedParam) > 0 {
@@ -301,84 +498,97 @@ func (p *TaskCmd) run() {
 					}
 					p.downloader.Context.Set(tk, val)
 				} else {
-					url,_ := p.downloader.Context.Get(tk)
+					url, _ := p.downloader.Context.Get(tk)
 					p.url = url.(string)
 				}
 			}
 		}
 
 		if !step.passCondition(p.downloader.Context) {
-			dlog.Warn("skip step %d", c)
-			c++
+			dlog.Warn("skip step %d", p.step)
+			p.step++
 			continue
 		}
 
 		err := step.Do(p.downloader, p.dama2Client, p.casperJS)
+		page := ""
+		if len(step.Page) > 0 {
+			page = p.downloader.LastPageUrl
+		}
+		p.history.AddProgress(p.step, page)
+		errorClass := ""
 		if nil != err {
 			dlog.Warn("%s downloader dostep fail: %v", p.GetId(), err)
+			errorClass = ErrorClass(err)
+			policy := step.errorPolicy(errorClass)
+			if policy == POLICY_FAIL {
+				msg := &cmd.Output{
+					Status:     cmd.FAIL,
+					Id:         p.GetArgsValue("id"),
+					Data:       err.Error(),
+					Url:        p.url,
+					ErrorClass: errorClass,
+				}
+				if errorClass == ERROR_HTTP_STATUS {
+					msg.Status = cmd.WRONG_RESPONSE
+					msg.Data = p.downloader.Context.Parse(step.Message["data"])
+				}
+				p.finish(msg)
+				dlog.Info("output msg: %v", msg)
+				return
+			}
+			if tag, ok := gotoTag(policy); ok {
+				if !p.gotoOnError(step, tag, errorClass, gotoMap) {
+					return
+				}
+				continue
+			}
 		}
 
 		if !p.task.DisableOutputFolder {
 			dlog.Println("begin save cookie")
 			/*
-			err = p.downloader.SaveCookie(p.downloader.OutputFolder + "/task_cookies.json")
-			if nil != err {
-				dlog.Warn("save cookie fail: %v", err)
-			}
+				err = p.downloader.SaveCookie(p.downloader.OutputFolder + "/task_cookies.json")
+				if nil != err {
+					dlog.Warn("save cookie fail: %v", err)
+				}
 			*/
 		}
 
-		if p.downloader.LastPageStatus/100 == 4 || p.downloader.LastPageStatus/100 == 5 {
-			msg := &cmd.Output{
-				Status: cmd.WRONG_RESPONSE,
-				Id:	p.GetArgsValue("id"),
-				Data:	p.downloader.Context.Parse(step.Message["data"]),
-				Url:	p.url,
-			}
-
-			p.message <- msg
-			dlog.Info("output msg: %v", msg)
-
-			p.finished = true
-			return
-		}
 		if step.Message != nil && len(step.Message) > 0 {
 			data := p.downloader.Context.Parse(step.Message["data"])
-			if p.proxy == nil && p.proxyManager.CheckTmpl(p.tmpl) == true{
-				data = strings.TrimSuffix(data, "}")+",\"block_time\":\""+p.task.TmplBlockTime+"\"}"
+			// the proxy of the context, since a retry may have switched it
+			if p.downloader.Context.Proxy == nil && p.proxyManager.CheckTmpl(p.tmpl) == true {
+				data = strings.TrimSuffix(data, "}") + ",\"block_time\":\"" + p.task.TmplBlockTime + "\"}"
 				msg := &cmd.Output{
-					Status: cmd.TMPL_BLOCK,
-					Id:	p.GetArgsValue("id"),
-					Data:	data,
-					Url: 	p.url,
+					Status:     cmd.TMPL_BLOCK,
+					Id:         p.GetArgsValue("id"),
+					Data:       data,
+					Url:        p.url,
+					ErrorClass: errorClass,
 				}
 				dlog.Println(data)
-				p.message <- msg
-
-				p.finished = true
+				p.finish(msg)
 				return
 
-
 			} else {
 				msg := &cmd.Output{
-					Status: step.Message["status"],
-					Id:     p.GetArgsValue("id"),
-					Data:   data,
-					Url:	p.url,
+					Status:     step.Message["status"],
+					Id:         p.GetArgsValue("id"),
+					Data:       data,
+					Url:        p.url,
+					ErrorClass: errorClass,
 				}
 
 				if needParam, ok := step.Message["need_param"]; ok {
 					msg.NeedParam = needParam
 				}
-				
-				if p.message != nil {
-				       p.message <- msg
-				 }
 
 				if msg.Status == cmd.FAIL || msg.Status == cmd.FINISH_FETCH_DATA {
-					p.finished = true
+					p.finish(p.checkSchema(msg))
 					return
 				}
+				p.sendMessage(msg)
 			}
 		}
 
@@ -387,26 +597,27 @@ func (p *TaskCmd) run() {
 			actionInfo := action.FullInfo(p.downloader.Context)
 			if action.Message != nil {
 				msg := &cmd.Output{
-					Status:    action.Message["status"],
-					Id:        p.GetArgsValue("id"),
-					NeedParam: action.Message["need_param"],
-					Data:      actionInfo,
-					Url:	   p.url,
+					Status:     action.Message["status"],
+					Id:         p.GetArgsValue("id"),
+					NeedParam:  action.Message["need_param"],
+					Data:       actionInfo,
+					Url:        p.url,
+					ErrorClass: errorClass,
 				}
 
-				p.message <- msg
 				if msg.Status == cmd.FAIL || msg.Status == cmd.FINISH_FETCH_DATA {
-					p.finished = true
+					p.finish(p.checkSchema(msg))
 					return
 				}
+				p.sendMessage(msg)
 			}
 
 			if len(action.Goto) > 0 {
-				nr, ok := retry[action.Goto]
+				nr, ok := p.retry[action.Goto]
 				if !ok {
-					retry[action.Goto] = 1
+					p.retry[action.Goto] = 1
 				} else {
-					retry[action.Goto] = nr + 1
+					p.retry[action.Goto] = nr + 1
 				}
 
 				dlog.Warn("%s retry count :%d", p.GetId(), nr)
@@ -420,16 +631,24 @@ func (p *TaskCmd) run() {
 						Status: cmd.FAIL,
 						Id:     p.GetArgsValue("id"),
 						Data:   actionInfo,
-						Url:	p.url,
+						Url:    p.url,
 					}
-					p.message <- msg
+					p.finish(msg)
 					dlog.Warn("%s Status:%s", p.GetId(), "retry fail "+step.Page)
 					return
 				} else if ok && nr < maxRetry {
-					c, ok = gotoMap[action.Goto]
-					dlog.Info("goto step %d with tag %s", c, action.Goto)
+					p.step, ok = gotoMap[action.Goto]
+					dlog.Info("goto step %d with tag %s", p.step, action.Goto)
 					if !ok {
-						dlog.Fatalln(p.GetId(), " can not find goto tag ", action.Goto)
+						dlog.Warn("%s can not find goto tag %s", p.GetId(), action.Goto)
+						p.finish(&cmd.Output{
+							Status:     cmd.FAIL,
+							Id:         p.GetArgsValue("id"),
+							Data:       "can not find goto tag " + action.Goto,
+							Url:        p.url,
+							ErrorClass: ERROR_TEMPLATE,
+						})
+						return
 					}
 				}
 			}
@@ -439,9 +658,12 @@ func (p *TaskCmd) run() {
 				p.downloader.Context.Del(d)
 			}
 		}
-		c++
+		p.step++
 	}
 
+	// an invalid output is still kept in the output folder for debugging, it
+	// only must not be reported as fetched
+	checked := p.checkSchema(&cmd.Output{Status: cmd.FINISH_FETCH_DATA})
 	if !p.task.DisableOutputFolder {
 		path := p.downloader.OutputFolder + "/ExtractorInfo.json"
 		saveFile, err := os.Create(path)
@@ -449,7 +671,8 @@ func (p *TaskCmd) run() {
 			saveFile.WriteString(p.downloader.ExtractorResultString())
 			saveFile.Close()
 		}
-		err = util.Tarit(p.downloader.OutputFolder, strings.TrimRight(p.downloader.OutputFolder, "/")+".tar")
+		// the fixture keeps the raw traffic, which is not for the uploaded data
+		err = util.Tarit(p.downloader.OutputFolder, strings.TrimRight(p.downloader.OutputFolder, "/")+".tar", FIXTURE_FILENAME)
 		if err != nil {
 			dlog.Warn("tar output from %s failed: %v", p.downloader.OutputFolder, err)
 		} else if p.flumeClient != nil {
@@ -462,13 +685,17 @@ func (p *TaskCmd) run() {
 		}
 	}
 
+	if checked.Status == cmd.INVALID_OUTPUT {
+		p.finish(checked)
+		return
+	}
+
 	message := &cmd.Output{
 		Status: cmd.FINISH_FETCH_DATA,
 		Id:     p.GetArgsValue("id"),
 		Data:   p.downloader.ExtractorResultString(),
-		Url:	p.url,
+		Url:    p.url,
 	}
 
-	p.message <- message
-	p.finished = true
+	p.finish(message)
 }
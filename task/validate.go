@@ -0,0 +1,192 @@
+package task
+
+import (
+	"fmt"
+	"github.com/xlvector/higgs/config"
+	"github.com/xlvector/higgs/context"
+	"github.com/xlvector/higgs/cookie"
+	"github.com/xlvector/higgs/extractor"
+	"github.com/xlvector/higgs/util"
+)
+
+type TemplateError struct {
+	File    string `json:"file"`
+	Step    int    `json:"step"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("%s step %d %s: %s", e.File, e.Step, e.Field, e.Message)
+}
+
+type taskValidator struct {
+	file   string
+	errors []*TemplateError
+}
+
+func (v *taskValidator) add(step int, field, format string, args ...interface{}) {
+	v.errors = append(v.errors, &TemplateError{
+		File:    v.file,
+		Step:    step,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *taskValidator) checkTemplate(step int, field, text string) {
+	if len(text) == 0 {
+		return
+	}
+	if err := context.Validate(text); err != nil {
+		v.add(step, field, "%v", err)
+	}
+}
+
+func hasPage(task *Task, page string) bool {
+	for _, step := range task.Steps {
+		if step.Page == page {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRequire checks the require steps of task, it should be called
+// before FixInclude which replaces them by the required steps.
+func (p *TaskManager) ValidateRequire(file string, task *Task) []*TemplateError {
+	v := &taskValidator{file: file}
+	for k, step := range task.Steps {
+		if step.Require == nil {
+			continue
+		}
+		reqTask, ok := p.tasks[step.Require.File]
+		if !ok || reqTask == nil {
+			v.add(k, "require.file", "can not find template %s", step.Require.File)
+			continue
+		}
+		if !hasPage(reqTask, step.Require.From) {
+			v.add(k, "require.from", "can not find page %s in %s", step.Require.From, step.Require.File)
+		}
+		if len(step.Require.To) > 0 && !hasPage(reqTask, step.Require.To) {
+			v.add(k, "require.to", "can not find page %s in %s", step.Require.To, step.Require.File)
+		}
+	}
+	return v.errors
+}
+
+func (p *TaskManager) Validate(file string, task *Task) []*TemplateError {
+	v := &taskValidator{file: file}
+	tags := make(map[string]bool)
+	for _, step := range task.Steps {
+		if len(step.Tag) > 0 {
+			tags[step.Tag] = true
+		}
+	}
+	if len(task.ClientProfile) > 0 {
+		if profile := config.GetClientProfile(task.ClientProfile); profile == nil {
+			v.add(-1, "client_profile", "can not find client profile %s", task.ClientProfile)
+		} else if _, err := NewTLSConfig(profile); err != nil {
+			v.add(-1, "client_profile", "%v", err)
+		}
+	}
+	if len(task.HeaderProfile) > 0 && config.GetHeaderProfile(task.HeaderProfile) == nil {
+		v.add(-1, "header_profile", "can not find header profile %s", task.HeaderProfile)
+	}
+	if task.Schema != nil {
+		if err := task.Schema.Check(); err != nil {
+			v.add(-1, "schema", "%v", err)
+		}
+	}
+	for k, step := range task.Steps {
+		if step.Require != nil {
+			continue
+		}
+		if !supportedMethods[step.Method] {
+			v.add(k, "method", "unsupported method %s", step.Method)
+		}
+		if !extractor.SupportDocType(step.DocType) {
+			v.add(k, "doc_type", "unsupported doc type %s", step.DocType)
+		}
+		if err := extractor.ValidatePipes(step.Extractor); err != nil {
+			v.add(k, "extractor", "%v", err)
+		}
+		v.checkTemplate(k, "page", step.Page)
+		v.checkTemplate(k, "condition", step.Condition)
+		v.checkTemplate(k, "output_filename", step.OutputFilename)
+		for pk, pv := range step.Params {
+			v.checkTemplate(k, "params", pk)
+			v.checkTemplate(k, "params."+pk, pv)
+		}
+		for hk, hv := range step.Header {
+			v.checkTemplate(k, "header", hk)
+			v.checkTemplate(k, "header."+hk, hv)
+		}
+		if r := step.HttpRetry; r != nil {
+			if r.Attempts < 0 || r.Backoff < 0 || r.MaxBackoff < 0 {
+				v.add(k, "http_retry", "negative attempts or backoff")
+			}
+			if r.Jitter < 0 || r.Jitter > 1 {
+				v.add(k, "http_retry.jitter", "jitter %v not in [0, 1]", r.Jitter)
+			}
+		}
+		if step.Download != nil {
+			if len(step.Download.Filename) == 0 {
+				v.add(k, "download.filename", "empty filename")
+			}
+			v.checkTemplate(k, "download.filename", step.Download.Filename)
+		}
+		if len(step.Charset) > 0 && !util.SupportCharset(step.Charset) {
+			v.add(k, "charset", "unsupported charset %s", step.Charset)
+		}
+		if step.Redirect != nil && step.Redirect.MaxHops < 0 {
+			v.add(k, "redirect.max_hops", "negative max_hops %d", step.Redirect.MaxHops)
+		}
+		if len(step.CookieFormat) > 0 && !cookie.SupportFormat(step.CookieFormat) {
+			v.add(k, "cookie_format", "unknown cookie format %s", step.CookieFormat)
+		}
+		v.checkTemplate(k, "cookiejar", step.CookieJar)
+		v.checkTemplate(k, "cookie_file", step.CookieFile)
+		if e := step.ExportCookies; e != nil {
+			if !cookie.SupportFormat(e.format()) {
+				v.add(k, "export_cookies.format", "unknown cookie format %s", e.Format)
+			}
+			v.checkTemplate(k, "export_cookies.filename", e.Filename)
+		}
+		v.checkTemplate(k, "body", step.Body)
+		if step.Multipart != nil {
+			for fk, fv := range step.Multipart.Fields {
+				v.checkTemplate(k, "multipart.fields", fk)
+				v.checkTemplate(k, "multipart.fields."+fk, fv)
+			}
+			for _, f := range step.Multipart.Files {
+				v.checkTemplate(k, "multipart.files.field", f.Field)
+				v.checkTemplate(k, "multipart.files.filename", f.Filename)
+				v.checkTemplate(k, "multipart.files.content", f.Content)
+			}
+		}
+		for _, co := range step.ContextOpers {
+			v.checkTemplate(k, "context_opers", co)
+		}
+		for class, policy := range step.OnError {
+			if class != "*" && !errorClasses[class] {
+				v.add(k, "on_error", "unknown error class %s", class)
+			}
+			if tag, ok := gotoTag(policy); ok {
+				if !tags[tag] {
+					v.add(k, "on_error."+class, "can not find tag %s", tag)
+				}
+			} else if policy != POLICY_CONTINUE && policy != POLICY_FAIL {
+				v.add(k, "on_error."+class, "unknown policy %s", policy)
+			}
+		}
+		for _, action := range step.Actions {
+			v.checkTemplate(k, "actions.condition", action.Condition)
+			v.checkTemplate(k, "actions.info", action.Info)
+			if len(action.Goto) > 0 && !tags[action.Goto] {
+				v.add(k, "actions.goto", "can not find tag %s", action.Goto)
+			}
+		}
+	}
+	return v.errors
+}
@@ -0,0 +1,59 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tmpls")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/base.json", []byte(baseTmpl), 0644)
+	ioutil.WriteFile(dir+"/bad.json", []byte(badTmpl), 0644)
+
+	tm := NewTaskManager(dir)
+	assert.NotNil(t, tm.GetByName("base.json"))
+	assert.Nil(t, tm.GetByName("bad.json"))
+
+	errs := tm.Errors()
+	assert.Equal(t, 0, len(errs["base.json"]))
+	fields := map[string]bool{}
+	for _, err := range errs["bad.json"] {
+		fields[err.Field] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"require.from": true,
+		"method":       true,
+		"doc_type":     true,
+		"extractor":    true,
+		"schema":       true,
+		"page":         true,
+		"actions.goto": true,
+		"on_error":     true,
+		"on_error.*":   true,
+	}, fields)
+}
+
+var baseTmpl = `
+{
+    "steps": [
+        {"page": "http://a.com/login", "tag": "login"},
+        {"page": "http://a.com/data"}
+    ]
+}
+`
+
+var badTmpl = `
+{
+    "schema": {"properties": {"bills": {"items": {"pattern": "(["}}}},
+    "steps": [
+        {"require": {"file": "base.json", "from": "http://a.com/none"}},
+        {"page": "http://a.com/{{.a", "method": "GETX", "doc_type": "yaml"},
+        {"page": "http://a.com/b", "actions": [{"condition": "true", "goto": "none"}]},
+        {"page": "http://a.com/c", "on_error": {"dns": "fail", "*": "goto:none"}},
+        {"page": "http://a.com/d", "extractor": {"amount": {"_query": "td", "_pipe": ["number:xx"]}}}
+    ]
+}
+`
@@ -0,0 +1,167 @@
+// tmplrun runs one template in the terminal without the http server:
+//
+//	tmplrun -tmpl taobao.json -args "userid=1&username=foo"
+//
+// every need_param is prompted on stdin and every output is printed to stdout.
+// With -record the http traffic is saved as a fixture, which -replay serves
+// back later without the network.
+package main
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/xlvector/higgs/cmd"
+	"github.com/xlvector/higgs/config"
+	hproxy "github.com/xlvector/higgs/proxy"
+	"github.com/xlvector/higgs/task"
+	"github.com/xlvector/higgs/util"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type runner struct {
+	command   cmd.Command
+	stdin     *bufio.Reader
+	publicKey *rsa.PublicKey
+	timeout   time.Duration
+}
+
+func (p *runner) prompt(key string) string {
+	fmt.Printf("%s: ", key)
+	line, err := p.stdin.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		log.Fatalln("read stdin failed:", err)
+	}
+	val := strings.TrimSpace(line)
+	if key == cmd.PARAM_PASSWORD && p.publicKey != nil {
+		enc, err := util.EncodePassword(val, p.publicKey)
+		if err != nil {
+			log.Fatalln("encode password failed:", err)
+		}
+		return enc
+	}
+	return val
+}
+
+func (p *runner) getMessage() *cmd.Output {
+	out, ok := p.command.GetMessageTimeout(p.timeout)
+	if !ok {
+		log.Fatalln("no output after", p.timeout)
+	}
+	return out
+}
+
+func (p *runner) run(args map[string]string) {
+	args["id"] = p.command.GetId()
+	p.command.SetInputArgs(args)
+	for {
+		out := p.getMessage()
+		if out == nil {
+			return
+		}
+		b, _ := json.Marshal(out)
+		fmt.Println(string(b))
+
+		if cmd.IsFinalStatus(out.Status) {
+			return
+		}
+		switch out.Status {
+		case cmd.OUTPUT_PUBLICKEY:
+			pub, err := util.ParsePublicKey([]byte(out.Data))
+			if err != nil {
+				log.Fatalln("parse public key failed:", err)
+			}
+			p.publicKey = pub
+			p.command.SetInputArgs(map[string]string{})
+			continue
+		case cmd.OUTPUT_VERIFYCODE, cmd.OUTPUT_QRCODE:
+			if len(out.NeedParam) == 0 {
+				out.NeedParam = cmd.PARAM_VERIFY_CODE
+			}
+		}
+
+		if len(out.NeedParam) > 0 {
+			input := make(map[string]string)
+			for _, key := range strings.Split(out.NeedParam, ",") {
+				input[key] = p.prompt(key)
+			}
+			p.command.SetInputArgs(input)
+		}
+
+		if p.command.Finished() {
+			return
+		}
+	}
+}
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	name := flag.String("tmpl", "", "template file name in tmpls dir, e.g. taobao.json")
+	dir := flag.String("dir", "./etc/tmpls/", "templates dir")
+	env := flag.String("env", "dev", "env")
+	out := flag.String("out", "", "output root, override OutputRoot in config")
+	args := flag.String("args", "", "initial args in query format, e.g. userid=1&username=foo")
+	timeout := flag.Duration("timeout", 5*time.Minute, "max time to wait for one output")
+	record := flag.Bool("record", false, "record http traffic to the fixture file in output folder")
+	replay := flag.String("replay", "", "replay http traffic from this fixture file instead of the network")
+	flag.Parse()
+
+	if len(*name) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !strings.HasSuffix(*name, ".json") {
+		*name += ".json"
+	}
+
+	config.Init("./etc/config_" + *env + ".json")
+	if len(*out) > 0 {
+		conf := *config.Get()
+		conf.OutputRoot = *out
+		config.Set(&conf)
+	}
+
+	tm := task.NewTaskManager(*dir)
+	t := tm.GetByName(*name)
+	if t == nil {
+		for _, err := range tm.Errors()[*name] {
+			fmt.Println(err)
+		}
+		log.Fatalln("can not load template", *name)
+	}
+
+	params, err := url.ParseQuery(*args)
+	if err != nil {
+		log.Fatalln("invalid args:", err)
+	}
+	params.Set("tmpl", strings.TrimSuffix(*name, ".json"))
+
+	factory := task.NewTaskCmdFactory(tm, hproxy.NewProxyManager(""))
+	if len(*replay) > 0 {
+		factory.SetFixture(task.FIXTURE_REPLAY, *replay)
+	} else if *record {
+		factory.SetFixture(task.FIXTURE_RECORD, "")
+	}
+	c := factory.CreateCommandWithTask(params, t)
+	if c == nil {
+		log.Fatalln("fail to create command")
+	}
+
+	input := make(map[string]string)
+	for k := range params {
+		input[k] = params.Get(k)
+	}
+	r := &runner{
+		command: c,
+		stdin:   bufio.NewReader(os.Stdin),
+		timeout: *timeout,
+	}
+	r.run(input)
+	c.Close()
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/text/encoding/htmlindex"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CHARSET_SNIFF_LEN = 4096
+)
+
+var (
+	xmlDeclReg  = regexp.MustCompile(`^\s*<\?xml[^>]*encoding=["']([\w.:-]+)["']`)
+	metaReg     = regexp.MustCompile(`(?i)<meta[^>]*charset\s*=\s*["']?\s*([\w.:-]+)`)
+	guessedSets = []string{"gbk", "big5", "shift_jis", "euc-kr"}
+)
+
+// commonRunes are frequent characters of Chinese in both scripts, Japanese
+// and Korean. Decoding a page with a wrong charset rarely produces them.
+var commonRunes = map[rune]bool{}
+
+func init() {
+	for _, r := range "的一是不了在人有我他这個个们們中来來上大为為和国國地到以说說时時要就出会會可也你对對生能而子那得于着下自之年过過发發后後作里裡用道行所然家种事成方多经經么麼去法学學如都同现現当當没沒动動面起看定天分还還进進好小部其些主样樣理心她本前开開但因只从從想实實日者意无無力与與长長把机機名金额額号號码碼户戶账賬单單期间間" +
+		"のにはをたがでてとしれさるいなかもすあります" +
+		"이다의는에가을를하고로서한지기사니어리자도정요수일대인시해보주" {
+		commonRunes[r] = true
+	}
+}
+
+func normalizeCharset(name string) string {
+	name = strings.ToLower(strings.Trim(strings.TrimSpace(name), `"'`))
+	if len(name) == 0 {
+		return ""
+	}
+	if name == "utf8" {
+		return "utf-8"
+	}
+	if _, err := htmlindex.Get(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+func sniffBOM(body []byte) string {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	}
+	return ""
+}
+
+func guessCharset(body []byte) string {
+	best, bestScore := "", 0
+	for _, name := range guessedSets {
+		enc, _ := htmlindex.Get(name)
+		text, err := enc.NewDecoder().Bytes(body)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(text) {
+			if r == utf8.RuneError {
+				score -= 3
+			} else if commonRunes[r] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best
+}
+
+// DetectCharset returns the charset of body, looking at the BOM, the charset
+// of contentType, the xml declaration, the meta tags and at last guessing from
+// the content. It returns "" when the charset is unknown.
+func DetectCharset(body []byte, contentType string) string {
+	if cs := sniffBOM(body); len(cs) > 0 {
+		return cs
+	}
+	if i := strings.Index(strings.ToLower(contentType), "charset="); i >= 0 {
+		if cs := normalizeCharset(strings.SplitN(contentType[i+8:], ";", 2)[0]); len(cs) > 0 {
+			return cs
+		}
+	}
+	head := body
+	if len(head) > CHARSET_SNIFF_LEN {
+		head = head[:CHARSET_SNIFF_LEN]
+	}
+	if m := xmlDeclReg.FindSubmatch(head); m != nil {
+		if cs := normalizeCharset(string(m[1])); len(cs) > 0 {
+			return cs
+		}
+	}
+	if m := metaReg.FindSubmatch(head); m != nil {
+		if cs := normalizeCharset(string(m[1])); len(cs) > 0 {
+			return cs
+		}
+	}
+	if utf8.Valid(body) {
+		return "utf-8"
+	}
+	return guessCharset(body)
+}
+
+// ToUTF8 transcodes body from charset to utf-8, and drops the BOM.
+func ToUTF8(body []byte, charset string) ([]byte, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, errors.New("unsupported charset " + charset)
+	}
+	name, _ := htmlindex.Name(enc)
+	if name != "utf-8" {
+		body, err = enc.NewDecoder().Bytes(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bytes.TrimPrefix(body, []byte{0xef, 0xbb, 0xbf}), nil
+}
+
+func SupportCharset(name string) bool {
+	return len(normalizeCharset(name)) > 0
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/htmlindex"
+	"testing"
+)
+
+func encodeTo(charset, text string) []byte {
+	enc, _ := htmlindex.Get(charset)
+	b, _ := enc.NewEncoder().Bytes([]byte(text))
+	return b
+}
+
+func TestDetectCharset(t *testing.T) {
+	big5 := encodeTo("big5", "<html><body>這是我們的帳戶明細，本期應繳金額為一千元。</body></html>")
+	assert.Equal(t, "big5", DetectCharset(big5, "text/html"))
+	assert.Equal(t, "gbk", DetectCharset(encodeTo("gbk", "<p>这是我们的账户明细，本期应缴金额为一千元。</p>"), ""))
+	assert.Equal(t, "shift_jis", DetectCharset(encodeTo("shift_jis", "これはテストのページです。ご利用ありがとうございます。"), ""))
+	assert.Equal(t, "euc-kr", DetectCharset(encodeTo("euc-kr", "이것은 테스트 페이지입니다. 감사합니다."), ""))
+
+	assert.Equal(t, "big5", DetectCharset(big5, "text/html; charset=Big5"))
+	assert.Equal(t, "windows-1252", DetectCharset([]byte("caf\xe9"), "text/html; charset=windows-1252"))
+	assert.Equal(t, "big5", DetectCharset([]byte(`<?xml version="1.0" encoding="big5"?><a/>`), "text/xml"))
+	assert.Equal(t, "big5", DetectCharset([]byte(`<meta http-equiv="Content-Type" content="text/html; charset=big5">`), ""))
+	assert.Equal(t, "shift_jis", DetectCharset([]byte(`<meta charset="shift_jis">`), ""))
+	assert.Equal(t, "utf-16le", DetectCharset([]byte{0xff, 0xfe, 'a', 0}, "text/html; charset=gbk"))
+	assert.Equal(t, "utf-8", DetectCharset([]byte("帳戶"), ""))
+	assert.Equal(t, "", DetectCharset([]byte{0xff, 0xff, 0xff}, ""))
+}
+
+func TestToUTF8(t *testing.T) {
+	b, err := ToUTF8(encodeTo("big5", "帳戶明細"), "big5")
+	assert.Nil(t, err)
+	assert.Equal(t, "帳戶明細", string(b))
+	b, _ = ToUTF8([]byte("caf\xe9"), "iso-8859-1")
+	assert.Equal(t, "café", string(b))
+	b, _ = ToUTF8([]byte("\xef\xbb\xbfabc"), "utf-8")
+	assert.Equal(t, "abc", string(b))
+	_, err = ToUTF8([]byte("abc"), "none")
+	assert.NotNil(t, err)
+}
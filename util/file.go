@@ -0,0 +1,32 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LastModTime returns the latest modification time of the given files, and of
+// the files directly under the given dirs.
+func LastModTime(paths ...string) time.Time {
+	var ret time.Time
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(ret) {
+			ret = info.ModTime()
+		}
+		if !info.IsDir() {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(p, "*"))
+		for _, f := range files {
+			if fi, err := os.Stat(f); err == nil && fi.ModTime().After(ret) {
+				ret = fi.ModTime()
+			}
+		}
+	}
+	return ret
+}
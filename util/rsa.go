@@ -18,6 +18,11 @@ import (
 )
 
 func DecodePassword(p string, privateKey *rsa.PrivateKey) string {
+	if privateKey == nil {
+		// a session restored without its key
+		dlog.Warn("no private key to decode password")
+		return ""
+	}
 	bp, err := hex.DecodeString(p)
 	if err != nil {
 		dlog.Warn("decode password hex error:%s", err.Error())
@@ -32,6 +37,30 @@ func DecodePassword(p string, privateKey *rsa.PrivateKey) string {
 	return string(out)
 }
 
+func EncodePassword(p string, publicKey *rsa.PublicKey) (string, error) {
+	out, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, []byte(p), []byte(""))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(out), nil
+}
+
+func ParsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid public key pem")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not rsa public key")
+	}
+	return pub, nil
+}
+
 func GenerateRSAKey() (*rsa.PrivateKey, error) {
 	privKey, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
@@ -65,6 +94,14 @@ func PrivateKeyString(key *rsa.PrivateKey) []byte {
 		})
 }
 
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid private key pem")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
 func pkcs1pad2(s []byte, n int) (*big.Int, error) {
 	if n < len(s)+11 {
 		return nil, rsa.ErrMessageTooLong
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestEncodePassword(t *testing.T) {
+	pk, err := GenerateRSAKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pub, err := ParsePublicKey(PublicKeyString(&pk.PublicKey))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc, err := EncodePassword("hello", pub)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pwd := DecodePassword(enc, pk); pwd != "hello" {
+		t.Error(pwd)
+	}
+}
@@ -8,7 +8,8 @@ import (
 	"strings"
 )
 
-func Tarit(source, target string) error {
+// Tarit archives source to target, leaving out the files named in skip.
+func Tarit(source, target string, skip ...string) error {
 	tarfile, err := os.Create(target)
 	if err != nil {
 		return err
@@ -33,6 +34,11 @@ func Tarit(source, target string) error {
 			if err != nil {
 				return err
 			}
+			for _, name := range skip {
+				if !info.IsDir() && info.Name() == name {
+					return nil
+				}
+			}
 			header, err := tar.FileInfoHeader(info, info.Name())
 			if err != nil {
 				return err
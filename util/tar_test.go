@@ -0,0 +1,31 @@
+package util
+
+import (
+	"archive/tar"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTaritSkip(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tar")
+	defer os.RemoveAll(dir)
+	os.Mkdir(dir+"/out", 0700)
+	ioutil.WriteFile(dir+"/out/a.json", []byte("{}"), 0600)
+	ioutil.WriteFile(dir+"/out/skip.jsonl", []byte("{}"), 0600)
+	assert.NoError(t, Tarit(dir+"/out", dir+"/out.tar", "skip.jsonl"))
+
+	f, _ := os.Open(dir + "/out.tar")
+	defer f.Close()
+	names := []string{}
+	r := tar.NewReader(f)
+	for {
+		h, err := r.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, h.Name)
+	}
+	assert.Equal(t, []string{"out", "out/a.json"}, names)
+}
@@ -15,10 +15,11 @@ import (
 )
 
 func UploadFile(path string, bucket string) string {
+	conf := config.Get()
 	params := map[string]string{
-		"token": config.Instance.Buckets[bucket],
+		"token": conf.Buckets[bucket],
 	}
-	b, err := Upload(config.Instance.UploadApi, params, bucket, path)
+	b, err := Upload(conf.UploadApi, params, bucket, path)
 	if err != nil {
 		dlog.Println(err)
 		return ""
@@ -81,6 +82,7 @@ func Upload(link string, params map[string]string, paramName, path string) ([]by
 }
 
 func UploadBody(ubody []byte, path, bucket string) (string, error) {
+	conf := config.Get()
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 	part, err := writer.CreateFormFile(bucket, filepath.Base(path))
@@ -92,13 +94,13 @@ func UploadBody(ubody []byte, path, bucket string) (string, error) {
 		return "", err
 	}
 
-	writer.WriteField("token", config.Instance.Buckets[bucket])
+	writer.WriteField("token", conf.Buckets[bucket])
 	err = writer.Close()
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", config.Instance.UploadApi, body)
+	req, err := http.NewRequest("POST", conf.UploadApi, body)
 	if err != nil {
 		return "", err
 	}